@@ -20,8 +20,47 @@ const (
 	OpRemove
 	OpRename
 	OpChmod
+
+	// The following operations are not reported unless they are
+	// explicitly requested (see WithOpMask), as they can be very noisy.
+
+	// OpCloseWrite is reported when a file that was opened for writing
+	// is closed. This is the most reliable signal that a writer has
+	// finished writing to the file.
+	OpCloseWrite
+	// OpCloseNoWrite is reported when a file that was not opened for
+	// writing is closed.
+	OpCloseNoWrite
+	// OpOpen is reported when a file is opened.
+	OpOpen
+	// OpAccess is reported when a file is read.
+	OpAccess
+
+	// The following operations do not describe changes to a file, but
+	// mark points in the event stream. They are delivered regardless
+	// of the operations that were requested (see MarkerOpMask).
+
+	// OpOverflow is reported when events were lost, e.g. because the
+	// kernel queue overflowed, or because they were not read in time.
+	// The name of the event is empty. Consumers should assume that
+	// anything may have changed, and invalidate any state they derived
+	// from earlier events.
+	OpOverflow
+	// OpRestart is reported when the driver was restarted after it
+	// failed (see fsnotify.WithRestart). The name of the event is
+	// empty. Changes made while the driver was not running may not
+	// have been reported.
+	OpRestart
 )
 
+// MarkerOpMask is the set of operations that mark points in the event
+// stream instead of describing changes to a file.
+const MarkerOpMask = OpMask(OpOverflow | OpRestart)
+
+// DefaultOpMask is the set of operations that are reported for a
+// watch target when no explicit set of operations is requested.
+const DefaultOpMask = OpMask(OpCreate | OpWrite | OpRemove | OpRename | OpChmod)
+
 func (op Op) String() string {
 	switch op {
 	case OpCreate:
@@ -34,6 +73,18 @@ func (op Op) String() string {
 		return "RENAME"
 	case OpChmod:
 		return "CHMOD"
+	case OpCloseWrite:
+		return "CLOSE_WRITE"
+	case OpCloseNoWrite:
+		return "CLOSE_NOWRITE"
+	case OpOpen:
+		return "OPEN"
+	case OpAccess:
+		return "ACCESS"
+	case OpOverflow:
+		return "OVERFLOW"
+	case OpRestart:
+		return "RESTART"
 	default:
 		return "INVALID OP"
 	}
@@ -54,7 +105,7 @@ func (mask OpMask) IsSet(op Op) bool {
 func (mask OpMask) String() string {
 	var builder strings.Builder
 
-	for _, op := range []Op{OpCreate, OpRemove, OpWrite, OpRename, OpChmod} {
+	for _, op := range []Op{OpCreate, OpRemove, OpWrite, OpRename, OpChmod, OpCloseWrite, OpCloseNoWrite, OpOpen, OpAccess, OpOverflow, OpRestart} {
 		if uint32(mask)&uint32(op) == 0 {
 			continue
 		}
@@ -114,6 +165,39 @@ func (ev *event) String() string {
 	return builder.String()
 }
 
+// RenameEvent is an Event that describes a file that was moved from one
+// watched location to another. Name() returns the new name of the file.
+type RenameEvent interface {
+	Event
+
+	// OldName returns the name of the file before it was renamed
+	OldName() string
+}
+
+type renameEvent struct {
+	event
+	oldName string
+}
+
+func NewRenameEvent(oldName, newName string, mask OpMask) RenameEvent {
+	return &renameEvent{
+		event:   event{name: newName, mask: mask},
+		oldName: oldName,
+	}
+}
+
+func (ev *renameEvent) OldName() string {
+	return ev.oldName
+}
+
+func (ev *renameEvent) String() string {
+	var builder strings.Builder
+	builder.WriteString(strconv.Quote(ev.oldName))
+	builder.WriteString(` -> `)
+	builder.WriteString(ev.event.String())
+	return builder.String()
+}
+
 // EventSink is the destination where each Driver should send events to.
 type EventSink interface {
 	Event(Event)
@@ -129,9 +213,10 @@ type ErrorSink interface {
 type NilSink struct{}
 
 func (NilSink) Event(Event) {}
-func (NilSink) Error(error)     {}
+func (NilSink) Error(error) {}
 
 type ChanErrSink chan error
+
 func (s ChanErrSink) Error(err error) {
-	s<-err
+	s <- err
 }
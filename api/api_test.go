@@ -1,6 +1,10 @@
 package api_test
 
 import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
 	"testing"
 
 	"github.com/lestrrat-go/fsnotify/api"
@@ -33,6 +37,30 @@ func TestOp(t *testing.T) {
 				Op:       api.OpChmod,
 				Expected: "CHMOD",
 			},
+			{
+				Op:       api.OpCloseWrite,
+				Expected: "CLOSE_WRITE",
+			},
+			{
+				Op:       api.OpCloseNoWrite,
+				Expected: "CLOSE_NOWRITE",
+			},
+			{
+				Op:       api.OpOpen,
+				Expected: "OPEN",
+			},
+			{
+				Op:       api.OpAccess,
+				Expected: "ACCESS",
+			},
+			{
+				Op:       api.OpOverflow,
+				Expected: "OVERFLOW",
+			},
+			{
+				Op:       api.OpRestart,
+				Expected: "RESTART",
+			},
 			{
 				Op:       api.Op(0),
 				Expected: "INVALID OP",
@@ -82,5 +110,71 @@ func TestOpMask(t *testing.T) {
 		if !assert.Equal(t, "CREATE|WRITE", mask.String(), `multiple ops should be concatenated by |`) {
 			return
 		}
+
+		mask.Set(api.OpCloseWrite)
+		if !assert.Equal(t, "CREATE|WRITE|CLOSE_WRITE", mask.String(), `multiple ops should be concatenated by |`) {
+			return
+		}
+	})
+}
+
+func TestRenameEvent(t *testing.T) {
+	ev := api.NewRenameEvent("/old", "/new", api.OpMask(api.OpRename))
+	if !assert.Equal(t, "/old", ev.OldName(), `ev.OldName() should match`) {
+		return
+	}
+	if !assert.Equal(t, "/new", ev.Name(), `ev.Name() should match`) {
+		return
+	}
+	if !assert.Equal(t, `"/old" -> "/new" [RENAME]`, ev.String(), `ev.String() should match`) {
+		return
+	}
+}
+
+func TestError(t *testing.T) {
+	t.Run("Classification", func(t *testing.T) {
+		testcases := []struct {
+			Name     string
+			Err      error
+			Expected error
+		}{
+			{Name: "not found", Err: fs.ErrNotExist, Expected: api.ErrNotFound},
+			{Name: "permission", Err: &fs.PathError{Op: "open", Path: "/foo", Err: fs.ErrPermission}, Expected: api.ErrPermission},
+			{Name: "not watched", Err: api.ErrNotWatched, Expected: api.ErrNotWatched},
+			{Name: "watch limit", Err: fmt.Errorf(`wrapped: %w`, api.ErrWatchLimit), Expected: api.ErrWatchLimit},
+		}
+		for _, tc := range testcases {
+			tc := tc
+			t.Run(tc.Name, func(t *testing.T) {
+				err := api.NewError("test", "add", "/foo", tc.Err)
+				if !assert.ErrorIs(t, err, tc.Expected, `error should match the sentinel`) {
+					return
+				}
+				if !assert.ErrorIs(t, err, tc.Err, `error should match the underlying error`) {
+					return
+				}
+			})
+		}
+	})
+	t.Run("Unclassified", func(t *testing.T) {
+		err := api.NewError("test", "read", "", io.EOF)
+		for _, sentinel := range []error{api.ErrWatchLimit, api.ErrNotFound, api.ErrPermission, api.ErrNotWatched} {
+			if !assert.False(t, errors.Is(err, sentinel), `error should not match %s`, sentinel) {
+				return
+			}
+		}
+		if !assert.False(t, api.IsFatal(err), `error should not be fatal`) {
+			return
+		}
+	})
+	t.Run("Stringification", func(t *testing.T) {
+		err := api.NewError("test", "add", "/foo", fs.ErrNotExist)
+		if !assert.Equal(t, `test: add "/foo": file does not exist`, err.Error(), `error string should match`) {
+			return
+		}
+		err.Fatal = true
+		if !assert.True(t, api.IsFatal(fmt.Errorf(`wrapped: %w`, err)), `wrapped error should be fatal`) {
+			return
+		}
 	})
 }
@@ -61,6 +61,20 @@ func (q *CommandQueue) Append(cmd *Command) {
 const bufferProcessSize = 32
 
 func (q *CommandQueue) Drain(ctx context.Context) {
+	// Wake up the loop below when ctx is done, so that it does not
+	// wait for commands forever
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-done:
+		case <-ctx.Done():
+			q.cond.L.Lock()
+			q.cond.Broadcast()
+			q.cond.L.Unlock()
+		}
+	}()
+
 	pending := make([]*Command, bufferProcessSize)
 	for {
 		select {
@@ -71,14 +85,11 @@ func (q *CommandQueue) Drain(ctx context.Context) {
 
 		q.cond.L.Lock()
 		for len(q.pending) <= 0 {
-			q.cond.Wait()
-
-			select {
-			case <-ctx.Done():
+			if ctx.Err() != nil {
 				q.cond.L.Unlock()
 				return
-			default:
 			}
+			q.cond.Wait()
 		}
 
 		l := len(q.pending)
@@ -89,14 +100,17 @@ func (q *CommandQueue) Drain(ctx context.Context) {
 		}
 
 		n := copy(pending, q.pending)
-		q.pending = q.pending[:n]
+		q.pending = q.pending[n:]
 
 		q.cond.L.Unlock()
 
-		for _, v := range pending {
+		for i, v := range pending {
 			egress := q.chooser.Choose(v)
 			select {
 			case <-ctx.Done():
+				// The commands that were not passed on are left for
+				// the next call to Drain
+				q.requeue(pending[i:])
 				return
 			case egress <- v:
 			}
@@ -104,14 +118,24 @@ func (q *CommandQueue) Drain(ctx context.Context) {
 	}
 }
 
+// requeue puts back commands at the front of the queue
+func (q *CommandQueue) requeue(cmds []*Command) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.pending = append(append([]*Command(nil), cmds...), q.pending...)
+}
+
 func (q *CommandQueue) SendCmd(cmd *Command, options ...CommandOption) error {
 	var ack bool
+	ctx := context.Background()
 	for _, option := range options {
 		//nolint:forcetypeassert
 		ident := option.Ident()
 		switch {
 		case IsAck(ident):
 			ack = option.Value().(bool)
+		case IsContext(ident):
+			ctx = option.Value().(context.Context)
 		}
 	}
 
@@ -120,8 +144,16 @@ func (q *CommandQueue) SendCmd(cmd *Command, options ...CommandOption) error {
 	}
 
 	q.Append(cmd)
-	if ack {
-		return <-cmd.Reply
+	if !ack {
+		return nil
+	}
+
+	// The reply channel is buffered, so the driver does not block
+	// if nobody is waiting for the reply anymore
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case err := <-cmd.Reply:
+		return err
 	}
-	return nil
 }
@@ -0,0 +1,88 @@
+package api_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/lestrrat-go/fsnotify/api"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCommandQueue(t *testing.T) {
+	t.Run("Drain returns when ctx is done", func(t *testing.T) {
+		egress := make(chan *api.Command, 1)
+		q := api.NewCommandQueue(api.CommandQueueEgressChooseFunc(func(*api.Command) chan *api.Command {
+			return egress
+		}))
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			q.Drain(ctx)
+		}()
+
+		q.Append(&api.Command{Type: 1})
+		select {
+		case cmd := <-egress:
+			if !assert.Equal(t, 1, cmd.Type, `command should be passed on`) {
+				return
+			}
+		case <-time.After(time.Second):
+			assert.Fail(t, `timed out waiting for command`)
+			return
+		}
+
+		// Drain is now waiting for more commands
+		time.Sleep(50 * time.Millisecond)
+		cancel()
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			assert.Fail(t, `Drain should return when ctx is done`)
+		}
+	})
+	t.Run("Commands are kept when ctx is done", func(t *testing.T) {
+		egress := make(chan *api.Command)
+		q := api.NewCommandQueue(api.CommandQueueEgressChooseFunc(func(*api.Command) chan *api.Command {
+			return egress
+		}))
+		for i := 1; i <= 3; i++ {
+			q.Append(&api.Command{Type: i})
+		}
+
+		// Nobody receives the commands, so the first Drain is stopped
+		// while it is passing on the batch
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			q.Drain(ctx)
+		}()
+		time.Sleep(50 * time.Millisecond)
+		cancel()
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			assert.Fail(t, `Drain should return when ctx is done`)
+			return
+		}
+
+		ctx, cancel = context.WithCancel(context.Background())
+		defer cancel()
+		go q.Drain(ctx)
+		for i := 1; i <= 3; i++ {
+			select {
+			case cmd := <-egress:
+				if !assert.Equal(t, i, cmd.Type, `commands should be passed on in order`) {
+					return
+				}
+			case <-time.After(time.Second):
+				assert.Fail(t, `timed out waiting for command`)
+				return
+			}
+		}
+	})
+}
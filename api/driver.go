@@ -21,7 +21,15 @@ type Driver interface {
 	//
 	// The third and fourht parameters are where events and errors
 	// should be sent from the Driver
-	Run(context.Context, chan struct{}, EventSink, ErrorSink)
+	//
+	// Run must keep running until the context is done, and then return
+	// nil. Errors that only affect a single operation or event are sent
+	// to the ErrorSink. If the driver can not continue, either because
+	// it failed to start (in which case the ready channel is never
+	// closed) or because of a failure while running, Run returns the
+	// error that caused it to stop. Such errors should be *Error values
+	// with Fatal set to true.
+	Run(context.Context, chan struct{}, EventSink, ErrorSink) error
 }
 
 // NullDriver exists to be plugged in when there are no other
@@ -35,4 +43,8 @@ func (_ NullDriver) Add(_ string, _ ...CommandOption) error {
 func (_ NullDriver) Remove(_ string, _ ...CommandOption) error {
 	return nil
 }
-func (_ NullDriver) Run(_ context.Context, _ EventSink, _ ErrorSink) {}
+func (_ NullDriver) Run(ctx context.Context, ready chan struct{}, _ EventSink, _ ErrorSink) error {
+	close(ready)
+	<-ctx.Done()
+	return nil
+}
@@ -0,0 +1,106 @@
+package api
+
+import (
+	"errors"
+	"io/fs"
+	"strconv"
+	"strings"
+)
+
+// Sentinel errors that describe common failures regardless of the
+// driver that reported them. Use errors.Is to check for them.
+var (
+	// ErrWatchLimit is reported when the driver ran into a limit on
+	// the number of watches (or similar resources) imposed by the system.
+	ErrWatchLimit = errors.New(`watch limit reached`)
+	// ErrNotFound is reported when the path does not exist.
+	ErrNotFound = errors.New(`path not found`)
+	// ErrPermission is reported when access to the path was denied.
+	ErrPermission = errors.New(`permission denied`)
+	// ErrNotWatched is reported when a path that is not being watched
+	// is removed.
+	ErrNotWatched = errors.New(`path is not being watched`)
+)
+
+// Error is the error type that drivers use to report failures. It
+// describes what the driver was doing when the error occurred.
+type Error struct {
+	// Driver is the name of the driver that reported the error
+	Driver string
+	// Op is the operation that failed, e.g. "add" or "remove"
+	Op string
+	// Path is the path that the operation was performed on, if any
+	Path string
+	// Fatal is true if the driver can not continue after the error.
+	// Errors that are not fatal only affect the operation that failed.
+	Fatal bool
+	// Kind is one of the sentinel errors in this package (e.g.
+	// ErrNotFound) that classifies the error, or nil if it does not
+	// fall in any of those categories.
+	Kind error
+	// Err is the underlying error
+	Err error
+}
+
+// NewError creates a new Error. The kind of error is derived from
+// err: errors that match fs.ErrNotExist are classified as ErrNotFound,
+// errors that match fs.ErrPermission are classified as ErrPermission,
+// and errors that match one of the sentinels are classified as such.
+// Drivers may set Kind themselves for errors that are specific to them.
+func NewError(driver, op, path string, err error) *Error {
+	e := &Error{
+		Driver: driver,
+		Op:     op,
+		Path:   path,
+		Err:    err,
+	}
+	switch {
+	case errors.Is(err, ErrWatchLimit):
+		e.Kind = ErrWatchLimit
+	case errors.Is(err, ErrNotWatched):
+		e.Kind = ErrNotWatched
+	case errors.Is(err, ErrNotFound), errors.Is(err, fs.ErrNotExist):
+		e.Kind = ErrNotFound
+	case errors.Is(err, ErrPermission), errors.Is(err, fs.ErrPermission):
+		e.Kind = ErrPermission
+	}
+	return e
+}
+
+func (e *Error) Error() string {
+	var builder strings.Builder
+	if e.Driver != "" {
+		builder.WriteString(e.Driver)
+		builder.WriteString(`: `)
+	}
+	if e.Op != "" {
+		builder.WriteString(e.Op)
+		if e.Path != "" {
+			builder.WriteByte(' ')
+			builder.WriteString(strconv.Quote(e.Path))
+		}
+		builder.WriteString(`: `)
+	}
+	if e.Err != nil {
+		builder.WriteString(e.Err.Error())
+	} else if e.Kind != nil {
+		builder.WriteString(e.Kind.Error())
+	}
+	return builder.String()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Is reports whether the error is of the given kind, so that
+// errors.Is(err, api.ErrNotFound) works for errors from any driver.
+func (e *Error) Is(target error) bool {
+	return e.Kind != nil && e.Kind == target
+}
+
+// IsFatal returns true if err is an *Error that is marked as fatal
+func IsFatal(err error) bool {
+	var e *Error
+	return errors.As(err, &e) && e.Fatal
+}
@@ -1,9 +1,20 @@
 package api
 
-import "github.com/lestrrat-go/option"
+import (
+	"context"
+
+	"github.com/lestrrat-go/option"
+)
 
 type Option = option.Interface
 type identAck struct{}
+type identContext struct{}
+type identRecursive struct{}
+type identOpMask struct{}
+type identExcludeDir struct{}
+type identPending struct{}
+type identFollowName struct{}
+type identFollowInode struct{}
 
 type CommandOption interface {
 	Option
@@ -23,3 +34,94 @@ func IsAck(ident interface{}) bool {
 func WithAck(b bool) CommandOption {
 	return &commandOption{option.New(identAck{}, b)}
 }
+
+func IsContext(ident interface{}) bool {
+	return ident == identContext{}
+}
+
+// WithContext specifies a context that limits how long a command that
+// is sent with WithAck waits for the driver to acknowledge it. If ctx
+// is done first, ctx.Err() is returned, and the acknowledgement is
+// discarded.
+func WithContext(ctx context.Context) CommandOption {
+	return &commandOption{option.New(identContext{}, ctx)}
+}
+
+func IsRecursive(ident interface{}) bool {
+	return ident == identRecursive{}
+}
+
+// WithRecursive specifies that the target directory should be watched
+// recursively: every directory below it is watched as well, including
+// directories that are created after the watch has been established.
+func WithRecursive(b bool) CommandOption {
+	return &commandOption{option.New(identRecursive{}, b)}
+}
+
+func IsOpMask(ident interface{}) bool {
+	return ident == identOpMask{}
+}
+
+// WithOpMask specifies the set of operations that should be reported
+// for the watch target. If omitted, DefaultOpMask is used.
+//
+// When the same target is added multiple times with different
+// masks, the masks are merged, and all of the requested operations
+// are reported.
+func WithOpMask(mask OpMask) CommandOption {
+	return &commandOption{option.New(identOpMask{}, mask)}
+}
+
+func IsExcludeDir(ident interface{}) bool {
+	return ident == identExcludeDir{}
+}
+
+// WithExcludeDir specifies a function that is consulted when directories
+// are added as part of a recursive watch (see WithRecursive). Directories
+// for which fn returns true are not watched, and neither is anything
+// below them.
+func WithExcludeDir(fn func(string) bool) CommandOption {
+	return &commandOption{option.New(identExcludeDir{}, fn)}
+}
+
+func IsPending(ident interface{}) bool {
+	return ident == identPending{}
+}
+
+// WithPending specifies that the target does not need to exist when it
+// is added. Until it is created, the nearest existing directory above it
+// is watched instead. Once the target is created, a CREATE event is
+// reported for it and it is watched as usual. If it is removed again,
+// the driver goes back to waiting for it.
+func WithPending(b bool) CommandOption {
+	return &commandOption{option.New(identPending{}, b)}
+}
+
+func IsFollowName(ident interface{}) bool {
+	return ident == identFollowName{}
+}
+
+// WithFollowName specifies that the watch is bound to the name of the
+// target rather than to the file that it refers to, like `tail -F`. When
+// the file is replaced, e.g. by an editor that writes a temporary file
+// and renames it over the original, the new file is watched in its place,
+// and a single WRITE event is reported for the name. If the file is
+// removed and created again, REMOVE and CREATE events are reported.
+func WithFollowName(b bool) CommandOption {
+	return &commandOption{option.New(identFollowName{}, b)}
+}
+
+func IsFollowInode(ident interface{}) bool {
+	return ident == identFollowInode{}
+}
+
+// WithFollowInode specifies that the watch for a file follows the file
+// when it is renamed or moved, like `tail -f`. A rename event carrying
+// the old and the new name is reported, and later events are reported
+// under the new name, which is also the name that the target must be
+// removed by. The rename event is reported even if OpRename was not
+// requested, so that callers can keep track of the target. Drivers that
+// can not tell where the file went report the move as usual.
+func WithFollowInode(b bool) CommandOption {
+	return &commandOption{option.New(identFollowInode{}, b)}
+}
@@ -0,0 +1,286 @@
+package fsnotify
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/lestrrat-go/fsnotify/api"
+	"github.com/lestrrat-go/fsnotify/snapshot"
+)
+
+const (
+	checkpointVersion         = 1
+	defaultCheckpointInterval = time.Minute
+)
+
+// checkpoint is the state of the watch targets, as stored on disk
+type checkpoint struct {
+	Version int                          `json:"version"`
+	Targets map[string]*checkpointTarget `json:"targets"`
+}
+
+type checkpointTarget struct {
+	Recursive bool              `json:"recursive"`
+	Snapshot  snapshot.Snapshot `json:"snapshot"`
+}
+
+// loadCheckpoint reads the checkpoint stored in path. A missing
+// file is not an error, as there is nothing to catch up with the
+// first time around.
+func loadCheckpoint(path string) (*checkpoint, error) {
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return &checkpoint{}, nil
+		}
+		return nil, fmt.Errorf(`failed to read checkpoint: %w`, err)
+	}
+
+	var cp checkpoint
+	if err := json.Unmarshal(buf, &cp); err != nil {
+		return nil, fmt.Errorf(`failed to parse checkpoint %q: %w`, path, err)
+	}
+	if cp.Version != checkpointVersion {
+		return nil, fmt.Errorf(`unsupported checkpoint version %d in %q`, cp.Version, path)
+	}
+	return &cp, nil
+}
+
+// save writes the checkpoint to path. The file is replaced atomically,
+// so that a crash never leaves a truncated checkpoint behind.
+func (cp *checkpoint) save(path string) error {
+	buf, err := json.Marshal(cp)
+	if err != nil {
+		return fmt.Errorf(`failed to serialize checkpoint: %w`, err)
+	}
+
+	f, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return fmt.Errorf(`failed to create checkpoint: %w`, err)
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := f.Write(buf); err != nil {
+		f.Close()
+		return fmt.Errorf(`failed to write checkpoint: %w`, err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf(`failed to write checkpoint: %w`, err)
+	}
+	if err := os.Rename(f.Name(), path); err != nil {
+		return fmt.Errorf(`failed to write checkpoint: %w`, err)
+	}
+	return nil
+}
+
+// targetInfo summarizes the options of all `Add()` calls for a target
+type targetInfo struct {
+	recursive bool
+	ops       api.OpMask
+}
+
+func newTargetInfo(calls [][]CommandOption) targetInfo {
+	var info targetInfo
+	for _, options := range calls {
+		for _, option := range options {
+			switch ident := option.Ident(); {
+			case api.IsRecursive(ident):
+				//nolint:forcetypeassert
+				info.recursive = info.recursive || option.Value().(bool)
+			case api.IsOpMask(ident):
+				//nolint:forcetypeassert
+				info.ops |= option.Value().(api.OpMask)
+			}
+		}
+	}
+	if info.ops == 0 {
+		info.ops = api.DefaultOpMask
+	}
+	return info
+}
+
+// checkpointer persists the state of the watch targets while Watch
+// is running, and reports what changed since the last time around.
+// Without a path, the state is only kept in memory, which is enough
+// to report what changed while a failed driver was being restarted.
+type checkpointer struct {
+	watcher  *Watcher
+	path     string
+	interval time.Duration
+	exclude  func(string) bool
+	evSink   api.EventSink
+	errSink  api.ErrorSink
+	gate     *gateSink
+	last     *checkpoint
+}
+
+// load returns the state that was saved last
+func (c *checkpointer) load() *checkpoint {
+	if c.path == "" {
+		if c.last == nil {
+			return &checkpoint{}
+		}
+		return c.last
+	}
+
+	cp, err := loadCheckpoint(c.path)
+	if err != nil {
+		c.errSink.Error(err)
+		return &checkpoint{}
+	}
+	return cp
+}
+
+// store saves cp as the current state
+func (c *checkpointer) store(cp *checkpoint) {
+	c.last = cp
+	if c.path == "" {
+		return
+	}
+	if err := cp.save(c.path); err != nil {
+		c.errSink.Error(err)
+	}
+}
+
+// scan captures the current state of the watch targets
+func (c *checkpointer) scan(targets map[string]targetInfo) *checkpoint {
+	cp := &checkpoint{
+		Version: checkpointVersion,
+		Targets: make(map[string]*checkpointTarget),
+	}
+	for fn, info := range targets {
+		snap, errs := snapshot.Scan(fn,
+			snapshot.WithRecursive(info.recursive),
+			snapshot.WithExcludeDir(c.exclude),
+		)
+		for _, err := range errs {
+			c.errSink.Error(err)
+		}
+		cp.Targets[fn] = &checkpointTarget{
+			Recursive: info.recursive,
+			Snapshot:  snap,
+		}
+	}
+	return cp
+}
+
+func (c *checkpointer) save() {
+	c.store(c.scan(c.watcher.targetInfos()))
+}
+
+// run adds the watch targets to the driver, reports the changes since
+// the last checkpoint, and then lets live events through. From then
+// on, the state is saved periodically, and once more when ctx is done,
+// unless it is only kept in memory.
+func (c *checkpointer) run(ctx context.Context, addOptions []CommandOption) {
+	old := c.load()
+
+	// The targets must be watched before they are scanned, otherwise
+	// changes made in between would be lost
+	w := c.watcher
+	w.muTargets.RLock()
+	targetCalls := make(map[string][][]CommandOption, len(w.targets))
+	for fn, calls := range w.targets {
+		targetCalls[fn] = calls
+	}
+	w.muTargets.RUnlock()
+
+	targets := make(map[string]targetInfo, len(targetCalls))
+	for fn, calls := range targetCalls {
+		name := filepath.Clean(fn)
+		for _, options := range calls {
+			options = append(append(append([]CommandOption(nil), options...), addOptions...), api.WithAck(true), api.WithContext(ctx))
+			if err := callSync(ctx, func() error { return w.driver.Add(name, options...) }); err != nil {
+				c.errSink.Error(err)
+			}
+		}
+		targets[name] = newTargetInfo(calls)
+	}
+
+	select {
+	case <-ctx.Done():
+		return
+	default:
+	}
+
+	if c.path == "" && len(old.Targets) == 0 {
+		// Nothing to compare with, and nothing to persist
+		c.gate.open()
+		return
+	}
+
+	cur := c.scan(targets)
+	names := make([]string, 0, len(cur.Targets))
+	for fn := range cur.Targets {
+		names = append(names, fn)
+	}
+	sort.Strings(names)
+	for _, fn := range names {
+		prev, ok := old.Targets[fn]
+		if !ok || prev.Recursive != cur.Targets[fn].Recursive {
+			// Nothing to compare with
+			continue
+		}
+		for _, ev := range snapshot.Diff(prev.Snapshot, cur.Targets[fn].Snapshot) {
+			for _, ev := range snapshot.Filter(ev, targets[fn].ops) {
+				c.evSink.Event(ev)
+			}
+		}
+	}
+	c.store(cur)
+	c.gate.open()
+	if c.path == "" {
+		return
+	}
+
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			c.save()
+			return
+		case <-ticker.C:
+			c.save()
+		}
+	}
+}
+
+// gateSink holds on to events until it is opened, and forwards them
+// as they come in afterwards
+type gateSink struct {
+	mu       sync.Mutex
+	dst      api.EventSink
+	opened   bool
+	buffered []api.Event
+}
+
+func (sink *gateSink) Event(ev api.Event) {
+	sink.mu.Lock()
+	if !sink.opened {
+		sink.buffered = append(sink.buffered, ev)
+		sink.mu.Unlock()
+		return
+	}
+	sink.mu.Unlock()
+	sink.dst.Event(ev)
+}
+
+// open forwards the events that were held, and lets everything
+// else through from then on
+func (sink *gateSink) open() {
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+	for _, ev := range sink.buffered {
+		sink.dst.Event(ev)
+	}
+	sink.buffered = nil
+	sink.opened = true
+}
@@ -0,0 +1,134 @@
+package fsnotify_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/lestrrat-go/fsnotify"
+	"github.com/lestrrat-go/fsnotify/api"
+	"github.com/lestrrat-go/fsnotify/fsnotifytest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckpoint(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fsnotify-test-*")
+	if !assert.NoError(t, err, `ioutil.TempDir should succeed`) {
+		return
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	stateDir, err := ioutil.TempDir("", "fsnotify-test-*")
+	if !assert.NoError(t, err, `ioutil.TempDir should succeed`) {
+		return
+	}
+	t.Cleanup(func() { os.RemoveAll(stateDir) })
+	checkpoint := filepath.Join(stateDir, "checkpoint.json")
+
+	written := filepath.Join(dir, "written")
+	removed := filepath.Join(dir, "removed")
+	created := filepath.Join(dir, "sub", "created")
+	for _, name := range []string{written, removed} {
+		if !assert.NoError(t, ioutil.WriteFile(name, []byte(`Hello`), 0644), `ioutil.WriteFile should succeed`) {
+			return
+		}
+	}
+
+	// watch runs Watch with a checkpoint until cancel is called, and
+	// sends a live event as soon as the target is added to the driver
+	watch := func(ctx context.Context, eventCh chan api.Event) chan struct{} {
+		driver := fsnotifytest.NewDriver()
+		watcher := fsnotify.Create(driver)
+		watcher.Add(dir, fsnotify.WithRecursive(true))
+
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			watcher.Watch(ctx,
+				fsnotify.WithEventSink(fsnotify.ChannelEventSink(eventCh)),
+				fsnotify.WithCheckpoint(checkpoint),
+			)
+		}()
+		go func() {
+			if err := driver.WaitForTarget(ctx, dir); err != nil {
+				return
+			}
+			driver.SendEvent(ctx, api.NewEvent(filepath.Join(dir, "live"), api.OpMask(api.OpCreate)))
+		}()
+		return done
+	}
+
+	t.Run("First run", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		eventCh := make(chan api.Event)
+		done := watch(ctx, eventCh)
+
+		// There is nothing to catch up with, so the live event comes first
+		select {
+		case ev := <-eventCh:
+			if !assert.Equal(t, filepath.Join(dir, "live"), ev.Name(), `live event should be delivered`) {
+				return
+			}
+		case <-ctx.Done():
+			assert.Fail(t, `timed out waiting for event`)
+			return
+		}
+		cancel()
+		<-done
+
+		_, err := os.Stat(checkpoint)
+		if !assert.NoError(t, err, `checkpoint should be saved`) {
+			return
+		}
+	})
+
+	// Make changes while nobody is watching
+	if !assert.NoError(t, ioutil.WriteFile(written, []byte(`Hello, World!`), 0644), `ioutil.WriteFile should succeed`) {
+		return
+	}
+	if !assert.NoError(t, os.MkdirAll(filepath.Dir(created), 0755), `os.MkdirAll should succeed`) {
+		return
+	}
+	if !assert.NoError(t, ioutil.WriteFile(created, []byte(`Hello`), 0644), `ioutil.WriteFile should succeed`) {
+		return
+	}
+	if !assert.NoError(t, os.Remove(removed), `os.Remove should succeed`) {
+		return
+	}
+
+	t.Run("Second run", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		eventCh := make(chan api.Event)
+		done := watch(ctx, eventCh)
+		defer func() {
+			cancel()
+			<-done
+		}()
+
+		expected := []api.Event{
+			api.NewEvent(removed, api.OpMask(api.OpRemove)),
+			api.NewEvent(filepath.Dir(created), api.OpMask(api.OpCreate)),
+			api.NewEvent(created, api.OpMask(api.OpCreate)),
+			api.NewEvent(written, api.OpMask(api.OpWrite)),
+			api.NewEvent(filepath.Join(dir, "live"), api.OpMask(api.OpCreate)),
+		}
+		for i, want := range expected {
+			select {
+			case ev := <-eventCh:
+				if !assert.Equal(t, want.String(), ev.String(), `events[%d] should match`, i) {
+					return
+				}
+			case <-ctx.Done():
+				assert.Fail(t, `timed out waiting for event`)
+				return
+			}
+		}
+	})
+}
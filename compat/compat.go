@@ -0,0 +1,268 @@
+// Package compat provides the API of github.com/fsnotify/fsnotify on
+// top of fsnotify.Watcher, so that code written against it can be
+// migrated by changing the import path:
+//
+//	import fsnotify "github.com/lestrrat-go/fsnotify/compat"
+//
+// Only the operations that github.com/fsnotify/fsnotify knows about are
+// reported. Renames that were paired by the driver are reported as a
+// Rename event for the old name, followed by a Create event for the new
+// name, and lost events are reported as ErrEventOverflow.
+package compat
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/lestrrat-go/fsnotify"
+	"github.com/lestrrat-go/fsnotify/api"
+)
+
+var (
+	// ErrNonExistentWatch is returned by Remove for paths that are
+	// not being watched
+	ErrNonExistentWatch = errors.New(`fsnotify: can't remove non-existent watch`)
+	// ErrEventOverflow is sent to Errors when events were lost
+	ErrEventOverflow = errors.New(`fsnotify: queue or buffer overflow`)
+	// ErrClosed is returned when the Watcher is used after it was closed,
+	// or after the driver failed
+	ErrClosed = errors.New(`fsnotify: watcher already closed`)
+)
+
+// Op describes a set of file operations. The values are the same as
+// the corresponding api.Op values.
+type Op uint32
+
+const (
+	Create = Op(api.OpCreate)
+	Write  = Op(api.OpWrite)
+	Remove = Op(api.OpRemove)
+	Rename = Op(api.OpRename)
+	Chmod  = Op(api.OpChmod)
+)
+
+// Has reports whether op contains h
+func (op Op) Has(h Op) bool {
+	return op&h != 0
+}
+
+func (op Op) String() string {
+	if op == 0 {
+		return "[no events]"
+	}
+	return api.OpMask(op).String()
+}
+
+// Event represents a file system notification
+type Event struct {
+	// Name is the path to the file or directory
+	Name string
+	// Op is the set of operations that triggered the event
+	Op Op
+}
+
+// Has reports whether the event has op set
+func (e Event) Has(op Op) bool {
+	return e.Op.Has(op)
+}
+
+func (e Event) String() string {
+	return fmt.Sprintf("%-13s %q", e.Op.String(), e.Name)
+}
+
+// Watcher watches a set of paths, and delivers events on a channel
+type Watcher struct {
+	// Events sends the file system events
+	Events chan Event
+	// Errors sends the errors that occur while watching
+	Errors chan error
+
+	watcher   *fsnotify.Watcher
+	ctx       context.Context // done when Watch is no longer running
+	cancel    context.CancelFunc
+	done      chan struct{}
+	closing   chan struct{}
+	closeOnce sync.Once
+
+	// protects the channels from being closed while events are sent
+	muSend sync.RWMutex
+	closed bool
+
+	muNames sync.Mutex
+	names   map[string]struct{}
+}
+
+// NewWatcher creates a new Watcher using the default driver for the
+// platform, and starts watching.
+func NewWatcher() (*Watcher, error) {
+	return newWatcher(fsnotify.New(), 0), nil
+}
+
+// NewBufferedWatcher creates a new Watcher in the same way as
+// NewWatcher, except that the Events channel has a buffer of size sz.
+func NewBufferedWatcher(sz uint) (*Watcher, error) {
+	return newWatcher(fsnotify.New(), sz), nil
+}
+
+// NewWatcherWithDriver creates a new Watcher that uses the specified
+// driver, and starts watching.
+func NewWatcherWithDriver(d api.Driver) (*Watcher, error) {
+	return newWatcher(fsnotify.Create(d), 0), nil
+}
+
+func newWatcher(watcher *fsnotify.Watcher, sz uint) *Watcher {
+	ctx, cancel := context.WithCancel(context.Background())
+	w := &Watcher{
+		Events:  make(chan Event, sz),
+		Errors:  make(chan error),
+		watcher: watcher,
+		ctx:     ctx,
+		cancel:  cancel,
+		done:    make(chan struct{}),
+		closing: make(chan struct{}),
+		names:   make(map[string]struct{}),
+	}
+
+	go func() {
+		defer close(w.done)
+		err := watcher.Watch(ctx,
+			fsnotify.WithEventSink(eventSink{w}),
+			fsnotify.WithErrorSink(errorSink{w}),
+		)
+		if ctx.Err() == nil {
+			// The driver failed. The pending calls to Add and Remove
+			// must return, as nothing is going to handle them
+			cancel()
+			w.sendError(err)
+		}
+	}()
+	return w
+}
+
+// Add starts watching name, which may be a file or a directory.
+// Directories are not watched recursively.
+func (w *Watcher) Add(name string) error {
+	if w.ctx.Err() != nil {
+		return ErrClosed
+	}
+	if err := w.watcher.AddSync(w.ctx, name); err != nil {
+		if w.ctx.Err() != nil {
+			return ErrClosed
+		}
+		return err
+	}
+
+	w.muNames.Lock()
+	w.names[name] = struct{}{}
+	w.muNames.Unlock()
+	return nil
+}
+
+// Remove stops watching name. Removing a path that is not being
+// watched returns ErrNonExistentWatch.
+func (w *Watcher) Remove(name string) error {
+	if w.ctx.Err() != nil {
+		return ErrClosed
+	}
+
+	w.muNames.Lock()
+	delete(w.names, name)
+	w.muNames.Unlock()
+
+	if err := w.watcher.RemoveSync(w.ctx, name); err != nil {
+		if errors.Is(err, api.ErrNotWatched) {
+			return fmt.Errorf(`%w: %s`, ErrNonExistentWatch, name)
+		}
+		if w.ctx.Err() != nil {
+			return ErrClosed
+		}
+		return err
+	}
+	return nil
+}
+
+// WatchList returns the paths that were added and not removed
+func (w *Watcher) WatchList() []string {
+	w.muNames.Lock()
+	defer w.muNames.Unlock()
+
+	names := make([]string, 0, len(w.names))
+	for name := range w.names {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Close stops watching, and closes the Events and Errors channels.
+// It is safe to call it multiple times.
+func (w *Watcher) Close() error {
+	w.closeOnce.Do(func() { close(w.closing) })
+	w.cancel()
+	<-w.done
+
+	w.muSend.Lock()
+	defer w.muSend.Unlock()
+	if !w.closed {
+		w.closed = true
+		close(w.Events)
+		close(w.Errors)
+	}
+	return nil
+}
+
+func (w *Watcher) sendEvent(ev Event) {
+	w.muSend.RLock()
+	defer w.muSend.RUnlock()
+	if w.closed {
+		return
+	}
+	select {
+	case <-w.closing:
+	case w.Events <- ev:
+	}
+}
+
+func (w *Watcher) sendError(err error) {
+	w.muSend.RLock()
+	defer w.muSend.RUnlock()
+	if w.closed {
+		return
+	}
+	select {
+	case <-w.closing:
+	case w.Errors <- err:
+	}
+}
+
+type eventSink struct {
+	w *Watcher
+}
+
+func (sink eventSink) Event(ev api.Event) {
+	if ev.Mask().IsSet(api.OpOverflow) {
+		sink.w.sendError(ErrEventOverflow)
+		return
+	}
+
+	op := Op(ev.Mask() & api.DefaultOpMask)
+	if rev, ok := ev.(api.RenameEvent); ok {
+		sink.w.sendEvent(Event{Name: rev.OldName(), Op: Rename})
+		op = op&^Rename | Create
+	}
+	if op == 0 {
+		return
+	}
+	sink.w.sendEvent(Event{Name: ev.Name(), Op: op})
+}
+
+type errorSink struct {
+	w *Watcher
+}
+
+func (sink errorSink) Error(err error) {
+	sink.w.sendError(err)
+}
@@ -0,0 +1,169 @@
+package compat_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/lestrrat-go/fsnotify/api"
+	"github.com/lestrrat-go/fsnotify/compat"
+	"github.com/lestrrat-go/fsnotify/fsnotifytest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWatcher(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	driver := fsnotifytest.NewDriver()
+	w, err := compat.NewWatcherWithDriver(driver)
+	if !assert.NoError(t, err, `compat.NewWatcherWithDriver should succeed`) {
+		return
+	}
+	defer w.Close()
+
+	t.Run("Add", func(t *testing.T) {
+		if !assert.NoError(t, w.Add("/foo"), `w.Add should succeed`) {
+			return
+		}
+		if !assert.Contains(t, driver.Targets(), "/foo", `target should be added to the driver`) {
+			return
+		}
+		if !assert.Equal(t, []string{"/foo"}, w.WatchList(), `w.WatchList should return the target`) {
+			return
+		}
+
+		driver.FailAdd("/bar", api.NewError("fsnotifytest", "add", "/bar", api.ErrNotFound))
+		if !assert.ErrorIs(t, w.Add("/bar"), api.ErrNotFound, `w.Add should return the driver error`) {
+			return
+		}
+	})
+	t.Run("Events", func(t *testing.T) {
+		testcases := []struct {
+			Name     string
+			Event    api.Event
+			Expected []compat.Event
+		}{
+			{
+				Name:     "Create",
+				Event:    api.NewEvent("/foo/bar", api.OpMask(api.OpCreate)),
+				Expected: []compat.Event{{Name: "/foo/bar", Op: compat.Create}},
+			},
+			{
+				Name:     "Write and Chmod",
+				Event:    api.NewEvent("/foo/bar", api.OpMask(api.OpWrite|api.OpChmod)),
+				Expected: []compat.Event{{Name: "/foo/bar", Op: compat.Write | compat.Chmod}},
+			},
+			{
+				Name:  "Rename",
+				Event: api.NewRenameEvent("/foo/bar", "/foo/baz", api.OpMask(api.OpRename)),
+				Expected: []compat.Event{
+					{Name: "/foo/bar", Op: compat.Rename},
+					{Name: "/foo/baz", Op: compat.Create},
+				},
+			},
+		}
+
+		for _, tc := range testcases {
+			tc := tc
+			t.Run(tc.Name, func(t *testing.T) {
+				go driver.SendEvent(ctx, tc.Event)
+				for i, expected := range tc.Expected {
+					select {
+					case ev := <-w.Events:
+						if !assert.Equal(t, expected, ev, `events[%d] should match`, i) {
+							return
+						}
+					case <-ctx.Done():
+						assert.Fail(t, `timed out waiting for event`)
+						return
+					}
+				}
+			})
+		}
+	})
+	t.Run("Errors", func(t *testing.T) {
+		go driver.SendEvent(ctx, api.NewEvent("", api.OpMask(api.OpOverflow)))
+		select {
+		case err := <-w.Errors:
+			if !assert.Equal(t, compat.ErrEventOverflow, err, `overflow should be reported as an error`) {
+				return
+			}
+		case <-ctx.Done():
+			assert.Fail(t, `timed out waiting for error`)
+			return
+		}
+
+		failure := errors.New(`failure`)
+		go driver.SendError(ctx, failure)
+		select {
+		case err := <-w.Errors:
+			if !assert.Equal(t, failure, err, `error should be forwarded`) {
+				return
+			}
+		case <-ctx.Done():
+			assert.Fail(t, `timed out waiting for error`)
+			return
+		}
+	})
+	t.Run("Remove", func(t *testing.T) {
+		if !assert.NoError(t, w.Remove("/foo"), `w.Remove should succeed`) {
+			return
+		}
+		if !assert.NotContains(t, driver.Targets(), "/foo", `target should be removed from the driver`) {
+			return
+		}
+		if !assert.ErrorIs(t, w.Remove("/foo"), compat.ErrNonExistentWatch, `w.Remove should fail for paths that are not watched`) {
+			return
+		}
+	})
+	t.Run("Close", func(t *testing.T) {
+		if !assert.NoError(t, w.Close(), `w.Close should succeed`) {
+			return
+		}
+		if !assert.NoError(t, w.Close(), `w.Close should succeed when called twice`) {
+			return
+		}
+		_, ok := <-w.Events
+		if !assert.False(t, ok, `Events should be closed`) {
+			return
+		}
+		_, ok = <-w.Errors
+		if !assert.False(t, ok, `Errors should be closed`) {
+			return
+		}
+		if !assert.ErrorIs(t, w.Add("/foo"), compat.ErrClosed, `w.Add should fail after w.Close`) {
+			return
+		}
+	})
+}
+
+func TestDriverFailure(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	driver := fsnotifytest.NewDriver()
+	w, err := compat.NewWatcherWithDriver(driver)
+	if !assert.NoError(t, err, `compat.NewWatcherWithDriver should succeed`) {
+		return
+	}
+	defer w.Close()
+
+	failure := errors.New(`failure`)
+	if !assert.NoError(t, driver.Fail(ctx, failure), `driver.Fail should succeed`) {
+		return
+	}
+	select {
+	case err := <-w.Errors:
+		if !assert.Equal(t, failure, err, `driver failure should be reported`) {
+			return
+		}
+	case <-ctx.Done():
+		assert.Fail(t, `timed out waiting for error`)
+		return
+	}
+	if !assert.ErrorIs(t, w.Add("/foo"), compat.ErrClosed, `w.Add should fail after the driver failed`) {
+		return
+	}
+}
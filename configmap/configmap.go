@@ -0,0 +1,295 @@
+// Package configmap watches directories that are populated in the way
+// Kubernetes populates ConfigMap, Secret, downward API and projected
+// volumes:
+//
+//	/etc/config/..2024_01_01_00_00_00.000000000/key
+//	/etc/config/..data -> ..2024_01_01_00_00_00.000000000
+//	/etc/config/key -> ..data/key
+//
+// The contents of the volume are replaced at once by writing them to a
+// new hidden directory, and swapping the `..data` symbolic link over to
+// it. Watching such a directory directly produces a burst of events for
+// the hidden entries, and none for the paths that applications read.
+// Instead, a Watcher reports a single event for each of the paths whose
+// resolved content changed.
+//
+// Directories without a `..data` link are handled in the same way, which
+// makes it possible to use the same code outside of Kubernetes.
+package configmap
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/lestrrat-go/fsnotify"
+	"github.com/lestrrat-go/fsnotify/api"
+)
+
+// dataDir is the name of the symbolic link that points to the current
+// contents of the volume
+const dataDir = "..data"
+
+// Watcher reports changes to the files in a single directory. Entries
+// whose names start with ".." are considered to be part of the update
+// machinery, and are not reported. Symbolic links are followed, and
+// directories are descended into, so that events are reported under the
+// paths that applications use to read the files.
+//
+// Directories below the top level are watched as well. The targets of
+// symbolic links are only looked at again when `..data` is swapped, or
+// when the link itself changes.
+type Watcher struct {
+	dir        string
+	watcher    *fsnotify.Watcher
+	addOptions []fsnotify.CommandOption
+}
+
+// New creates a new Watcher for dir, using the default driver for the
+// platform
+func New(dir string) *Watcher {
+	return Create(dir, fsnotify.New())
+}
+
+// Create creates a new Watcher for dir on top of watcher, which must
+// not be used for anything else
+func Create(dir string, watcher *fsnotify.Watcher) *Watcher {
+	dir = filepath.Clean(dir)
+	return &Watcher{
+		dir:     dir,
+		watcher: watcher,
+		// The options are created once, so that the directory is not
+		// added over and over when Watch is called again
+		addOptions: []fsnotify.CommandOption{
+			fsnotify.WithRecursive(true),
+			api.WithExcludeDir(func(path string) bool { return hidden(dir, path) }),
+		},
+	}
+}
+
+// Watch starts watching, and blocks until ctx is done or the underlying
+// fsnotify.Watcher stops. The state of the files when Watch is called is
+// the baseline: only changes made afterwards are reported, as CREATE,
+// WRITE and REMOVE events.
+func (w *Watcher) Watch(ctx context.Context, options ...WatchOption) error {
+	var evsink api.EventSink = api.NilSink{}
+	var errsink api.ErrorSink = api.NilSink{}
+	for _, option := range options {
+		//nolint:forcetypeassert
+		switch option.Ident() {
+		case identEventSink{}:
+			evsink = option.Value().(api.EventSink)
+		case identErrorSink{}:
+			errsink = option.Value().(api.ErrorSink)
+		}
+	}
+
+	sink := &sink{
+		dir:     w.dir,
+		evsink:  evsink,
+		errsink: errsink,
+	}
+	sink.files = sink.scan()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	watchErr := make(chan error, 1)
+	go func() {
+		watchErr <- w.watcher.Watch(ctx,
+			fsnotify.WithEventSink(sink),
+			fsnotify.WithErrorSink(errsink),
+		)
+	}()
+
+	// Changes that were made before the directory was being watched
+	// are found by looking at it again once it is
+	if err := w.watcher.AddSync(ctx, w.dir, w.addOptions...); err != nil {
+		// Unless the watcher stopped by itself, it has to be stopped
+		stopped := errors.Is(err, fsnotify.ErrWatchStopped) || ctx.Err() != nil
+		cancel()
+		werr := <-watchErr
+		if stopped {
+			return werr
+		}
+		return fmt.Errorf(`failed to watch %q: %w`, w.dir, err)
+	}
+	sink.rescan()
+	return <-watchErr
+}
+
+// digest identifies the content of a file
+type digest [sha256.Size]byte
+
+// sink receives the events for the directory, and translates them into
+// events for the files whose content changed
+type sink struct {
+	dir     string
+	evsink  api.EventSink
+	errsink api.ErrorSink
+
+	mu    sync.Mutex
+	files map[string]digest // content of the files as of the last scan
+}
+
+func (s *sink) Event(ev api.Event) {
+	// Events for the hidden entries are part of an update that is
+	// not complete until `..data` is swapped, at which point anything
+	// may have changed. The same is true for marker events, which have
+	// no name, and mean that changes may have been missed. Other events
+	// only affect the path that they are about.
+	var paths []string
+	switch top := topLevel(s.dir, ev.Name()); {
+	case top == "" || top == dataDir:
+	case strings.HasPrefix(top, ".."):
+		return
+	default:
+		paths = append(paths, ev.Name())
+		if rev, ok := ev.(api.RenameEvent); ok && !hidden(s.dir, rev.OldName()) {
+			paths = append(paths, rev.OldName())
+		}
+	}
+
+	if paths == nil {
+		s.rescan()
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	old := make(map[string]digest)
+	cur := make(map[string]digest)
+	for _, path := range paths {
+		for file, d := range s.files {
+			if file == path || strings.HasPrefix(file, path+string(filepath.Separator)) {
+				old[file] = d
+			}
+		}
+		s.scanPath(cur, path)
+	}
+	for _, ev := range diff(old, cur) {
+		s.evsink.Event(ev)
+	}
+	for file := range old {
+		delete(s.files, file)
+	}
+	for file, d := range cur {
+		s.files[file] = d
+	}
+}
+
+// rescan reports the changes to all files since the last scan
+func (s *sink) rescan() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cur := s.scan()
+	for _, ev := range diff(s.files, cur) {
+		s.evsink.Event(ev)
+	}
+	s.files = cur
+}
+
+// topLevel returns the name of the entry directly below dir that path
+// is in, or an empty string if path is not below it
+func topLevel(dir, path string) string {
+	if path == "" {
+		return ""
+	}
+	rel, err := filepath.Rel(dir, path)
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return ""
+	}
+	return strings.SplitN(rel, string(filepath.Separator), 2)[0]
+}
+
+// hidden returns true if path is, or is below, one of the entries in
+// dir that are part of the update machinery
+func hidden(dir, path string) bool {
+	return strings.HasPrefix(topLevel(dir, path), "..")
+}
+
+// scan reads the current content of the files in the directory. Entries
+// that disappear while they are being read, e.g. symbolic links that
+// point to an old version of the volume, are left out.
+func (s *sink) scan() map[string]digest {
+	files := make(map[string]digest)
+	entries, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		s.errsink.Error(fmt.Errorf(`failed to read directory %q: %w`, s.dir, err))
+		return files
+	}
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), "..") {
+			continue
+		}
+		s.scanPath(files, filepath.Join(s.dir, entry.Name()))
+	}
+	return files
+}
+
+func (s *sink) scanPath(files map[string]digest, path string) {
+	// Stat follows symbolic links, which is the point
+	fi, err := os.Stat(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			s.errsink.Error(err)
+		}
+		return
+	}
+
+	if !fi.IsDir() {
+		buf, err := ioutil.ReadFile(path)
+		if err != nil {
+			if !os.IsNotExist(err) {
+				s.errsink.Error(err)
+			}
+			return
+		}
+		files[path] = sha256.Sum256(buf)
+		return
+	}
+
+	entries, err := ioutil.ReadDir(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			s.errsink.Error(fmt.Errorf(`failed to read directory %q: %w`, path, err))
+		}
+		return
+	}
+	for _, entry := range entries {
+		s.scanPath(files, filepath.Join(path, entry.Name()))
+	}
+}
+
+// diff returns the events that describe the changes from old to cur,
+// sorted by name
+func diff(old, cur map[string]digest) []api.Event {
+	var events []api.Event
+	for path, d := range cur {
+		prev, ok := old[path]
+		switch {
+		case !ok:
+			events = append(events, api.NewEvent(path, api.OpMask(api.OpCreate)))
+		case prev != d:
+			events = append(events, api.NewEvent(path, api.OpMask(api.OpWrite)))
+		}
+	}
+	for path := range old {
+		if _, ok := cur[path]; !ok {
+			events = append(events, api.NewEvent(path, api.OpMask(api.OpRemove)))
+		}
+	}
+	sort.Slice(events, func(i, j int) bool {
+		return events[i].Name() < events[j].Name()
+	})
+	return events
+}
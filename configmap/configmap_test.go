@@ -0,0 +1,191 @@
+//go:build linux
+// +build linux
+
+package configmap_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/lestrrat-go/fsnotify"
+	"github.com/lestrrat-go/fsnotify/api"
+	"github.com/lestrrat-go/fsnotify/configmap"
+	"github.com/lestrrat-go/fsnotify/inotify"
+	"github.com/stretchr/testify/assert"
+)
+
+type chanEventSink chan api.Event
+
+func (sink chanEventSink) Event(ev api.Event) {
+	sink <- ev
+}
+
+// update replaces the contents of dir in the same way as the kubelet
+// updates ConfigMap volumes
+func update(dir string, files map[string]string) error {
+	data := filepath.Join(dir, "..data")
+	old, err := os.Readlink(data)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	ts, err := ioutil.TempDir(dir, "..")
+	if err != nil {
+		return err
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(ts, name), []byte(content), 0644); err != nil {
+			return err
+		}
+	}
+
+	tmp := filepath.Join(dir, "..data_tmp")
+	if err := os.Symlink(filepath.Base(ts), tmp); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, data); err != nil {
+		return err
+	}
+
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if _, ok := files[entry.Name()]; !ok && entry.Name()[0] != '.' {
+			if err := os.Remove(filepath.Join(dir, entry.Name())); err != nil {
+				return err
+			}
+		}
+	}
+	for name := range files {
+		if err := os.Symlink(filepath.Join("..data", name), filepath.Join(dir, name)); err != nil && !os.IsExist(err) {
+			return err
+		}
+	}
+
+	if old != "" {
+		return os.RemoveAll(filepath.Join(dir, old))
+	}
+	return nil
+}
+
+func TestWatcher(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fsnotify-configmap-test-*")
+	if !assert.NoError(t, err, `ioutil.TempDir should succeed`) {
+		return
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	if !assert.NoError(t, update(dir, map[string]string{"a": "1", "b": "2"}), `update should succeed`) {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	evCh := make(chan api.Event, 128)
+	errCh := make(chan error, 128)
+	watcher := fsnotify.Create(inotify.New())
+	w := configmap.Create(dir, watcher)
+	go w.Watch(ctx, configmap.WithEventSink(chanEventSink(evCh)), configmap.WithErrorSink(api.ChanErrSink(errCh)))
+
+	// Wait until the directory is being watched
+	if !assert.NoError(t, watcher.AddSync(ctx, dir), `watcher.AddSync should succeed`) {
+		return
+	}
+
+	// collect returns the events that are reported until nothing happens
+	// for a while
+	collect := func(t *testing.T) []api.Event {
+		t.Helper()
+		var events []api.Event
+		for {
+			select {
+			case ev := <-evCh:
+				events = append(events, ev)
+			case err := <-errCh:
+				assert.NoError(t, err, `there should be no errors`)
+			case <-time.After(500 * time.Millisecond):
+				return events
+			}
+		}
+	}
+
+	t.Run("Update", func(t *testing.T) {
+		if !assert.NoError(t, update(dir, map[string]string{"a": "1", "b": "3", "c": "4"}), `update should succeed`) {
+			return
+		}
+		expected := []api.Event{
+			api.NewEvent(filepath.Join(dir, "b"), api.OpMask(api.OpWrite)),
+			api.NewEvent(filepath.Join(dir, "c"), api.OpMask(api.OpCreate)),
+		}
+		if !assert.ElementsMatch(t, expected, collect(t), `a single event should be reported for each file that changed`) {
+			return
+		}
+	})
+	t.Run("Remove", func(t *testing.T) {
+		if !assert.NoError(t, update(dir, map[string]string{"a": "1", "c": "4"}), `update should succeed`) {
+			return
+		}
+		expected := []api.Event{
+			api.NewEvent(filepath.Join(dir, "b"), api.OpMask(api.OpRemove)),
+		}
+		if !assert.Equal(t, expected, collect(t), `removed files should be reported`) {
+			return
+		}
+	})
+}
+
+func TestWatcherSubdirectory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fsnotify-configmap-test-*")
+	if !assert.NoError(t, err, `ioutil.TempDir should succeed`) {
+		return
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	sub := filepath.Join(dir, "sub")
+	if !assert.NoError(t, os.Mkdir(sub, 0755), `os.Mkdir should succeed`) {
+		return
+	}
+	name := filepath.Join(sub, "key")
+	if !assert.NoError(t, ioutil.WriteFile(name, []byte(`1`), 0644), `ioutil.WriteFile should succeed`) {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	evCh := make(chan api.Event, 128)
+	driver := inotify.New()
+	w := configmap.Create(dir, fsnotify.Create(driver))
+	go w.Watch(ctx, configmap.WithEventSink(chanEventSink(evCh)))
+
+	// Wait until both directories are being watched
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		usage, err := driver.Usage()
+		if err == nil && usage.Watches == 2 {
+			break
+		}
+		if time.Now().After(deadline) {
+			assert.Fail(t, `timed out waiting for the subdirectory to be watched`)
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if !assert.NoError(t, ioutil.WriteFile(name, []byte(`2`), 0644), `ioutil.WriteFile should succeed`) {
+		return
+	}
+	select {
+	case ev := <-evCh:
+		assert.Equal(t, api.NewEvent(name, api.OpMask(api.OpWrite)), ev, `changes in subdirectories should be reported`)
+	case <-time.After(5 * time.Second):
+		assert.Fail(t, `timed out waiting for event`)
+	}
+}
@@ -0,0 +1,33 @@
+package configmap
+
+import (
+	"github.com/lestrrat-go/fsnotify/api"
+	"github.com/lestrrat-go/option"
+)
+
+type Option = option.Interface
+type WatchOption interface {
+	Option
+	watchOption()
+}
+
+type watchOption struct {
+	Option
+}
+
+func (*watchOption) watchOption() {}
+
+type identEventSink struct{}
+type identErrorSink struct{}
+
+// WithEventSink specifies where the events for the files in the
+// directory are delivered
+func WithEventSink(sink api.EventSink) WatchOption {
+	return &watchOption{option.New(identEventSink{}, sink)}
+}
+
+// WithErrorSink specifies where errors that occur while watching,
+// or while reading the files in the directory, are delivered
+func WithErrorSink(sink api.ErrorSink) WatchOption {
+	return &watchOption{option.New(identErrorSink{}, sink)}
+}
@@ -0,0 +1,168 @@
+package fsnotify
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/lestrrat-go/fsnotify/api"
+)
+
+const defaultQuietPeriod = 100 * time.Millisecond
+
+// DebounceSink is an api.EventSink that coalesces bursts of events for
+// the same path into a single event. Events are held until no new event
+// has been seen for the path during the quiet period, and are then
+// forwarded to the wrapped sink as one event whose mask is the union of
+// the masks of all coalesced events.
+//
+// Events that are renames keep the name that the path was renamed from.
+// Marker events (see api.MarkerOpMask) are not held: the events that are
+// pending are forwarded first, followed by the marker event itself.
+//
+// Events are forwarded from a separate goroutine, but the wrapped sink
+// is never called concurrently.
+type DebounceSink struct {
+	dst      api.EventSink
+	quiet    time.Duration
+	maxDelay time.Duration
+
+	mu      sync.Mutex
+	pending map[string]*debounced
+
+	// muSend is held while events are forwarded. It is acquired
+	// before mu, so that pending events are forwarded in order with
+	// respect to marker events.
+	muSend sync.Mutex
+}
+
+// debounced holds the events for a single path
+type debounced struct {
+	event   api.Event // the first event, forwarded as is if nothing else comes in
+	mask    api.OpMask
+	oldName string // the name of the latest rename, if any
+	count   int
+	first   time.Time
+	timer   *time.Timer
+	gen     int // incremented every time the timer is rescheduled
+}
+
+// NewDebounceSink creates a new DebounceSink that forwards events to dst
+func NewDebounceSink(dst api.EventSink, options ...DebounceOption) *DebounceSink {
+	sink := &DebounceSink{
+		dst:     dst,
+		quiet:   defaultQuietPeriod,
+		pending: make(map[string]*debounced),
+	}
+	for _, option := range options {
+		//nolint:forcetypeassert
+		switch option.Ident() {
+		case identQuietPeriod{}:
+			sink.quiet = option.Value().(time.Duration)
+		case identMaxDelay{}:
+			sink.maxDelay = option.Value().(time.Duration)
+		}
+	}
+	return sink
+}
+
+func (sink *DebounceSink) Event(ev api.Event) {
+	if ev.Mask()&api.MarkerOpMask != 0 {
+		sink.muSend.Lock()
+		defer sink.muSend.Unlock()
+		sink.flush()
+		sink.dst.Event(ev)
+		return
+	}
+
+	name := ev.Name()
+	now := time.Now()
+
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+
+	entry, ok := sink.pending[name]
+	if !ok {
+		entry = &debounced{event: ev, first: now}
+		sink.pending[name] = entry
+	} else {
+		entry.timer.Stop()
+	}
+	entry.mask |= ev.Mask()
+	if rev, ok := ev.(api.RenameEvent); ok {
+		entry.oldName = rev.OldName()
+	}
+	entry.count++
+	entry.gen++
+
+	delay := sink.quiet
+	if sink.maxDelay > 0 {
+		if remaining := entry.first.Add(sink.maxDelay).Sub(now); remaining < delay {
+			delay = remaining
+		}
+	}
+
+	gen := entry.gen
+	entry.timer = time.AfterFunc(delay, func() {
+		sink.fire(name, gen)
+	})
+}
+
+func (sink *DebounceSink) fire(name string, gen int) {
+	sink.muSend.Lock()
+	defer sink.muSend.Unlock()
+
+	sink.mu.Lock()
+	entry, ok := sink.pending[name]
+	// The timer was rescheduled, or the entry was flushed
+	if !ok || entry.gen != gen {
+		sink.mu.Unlock()
+		return
+	}
+	delete(sink.pending, name)
+	sink.mu.Unlock()
+
+	sink.send(entry)
+}
+
+// Flush immediately forwards all events that are being held, in
+// the order of their names.
+func (sink *DebounceSink) Flush() {
+	sink.muSend.Lock()
+	defer sink.muSend.Unlock()
+	sink.flush()
+}
+
+// flush forwards all events that are being held. sink.muSend must be
+// held by the caller.
+func (sink *DebounceSink) flush() {
+	sink.mu.Lock()
+	entries := make([]*debounced, 0, len(sink.pending))
+	for name, entry := range sink.pending {
+		entry.timer.Stop()
+		entries = append(entries, entry)
+		delete(sink.pending, name)
+	}
+	sink.mu.Unlock()
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].event.Name() < entries[j].event.Name()
+	})
+	for _, entry := range entries {
+		sink.send(entry)
+	}
+}
+
+// send forwards the events for a single path. sink.muSend must be held
+// by the caller.
+func (sink *DebounceSink) send(entry *debounced) {
+	ev := entry.event
+	if entry.count > 1 {
+		if entry.oldName != "" {
+			ev = api.NewRenameEvent(entry.oldName, ev.Name(), entry.mask)
+		} else {
+			ev = api.NewEvent(ev.Name(), entry.mask)
+		}
+	}
+	sink.dst.Event(ev)
+}
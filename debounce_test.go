@@ -0,0 +1,148 @@
+package fsnotify_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/lestrrat-go/fsnotify"
+	"github.com/lestrrat-go/fsnotify/api"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDebounceSink(t *testing.T) {
+	t.Run("Coalesce", func(t *testing.T) {
+		ch := make(chan api.Event, 10)
+		sink := fsnotify.NewDebounceSink(fsnotify.ChannelEventSink(ch), fsnotify.WithQuietPeriod(50*time.Millisecond))
+
+		sink.Event(api.NewEvent("/foo", api.OpMask(api.OpWrite)))
+		sink.Event(api.NewEvent("/bar", api.OpMask(api.OpCreate)))
+		sink.Event(api.NewEvent("/foo", api.OpMask(api.OpChmod)))
+		sink.Event(api.NewEvent("/foo", api.OpMask(api.OpWrite)))
+
+		received := make(map[string]api.OpMask)
+		for i := 0; i < 2; i++ {
+			select {
+			case ev := <-ch:
+				received[ev.Name()] = ev.Mask()
+			case <-time.After(time.Second):
+				assert.Fail(t, `timed out waiting for event`)
+				return
+			}
+		}
+
+		var expected api.OpMask
+		expected.Set(api.OpWrite)
+		expected.Set(api.OpChmod)
+		if !assert.Equal(t, map[string]api.OpMask{"/foo": expected, "/bar": api.OpMask(api.OpCreate)}, received, `events should be coalesced per path`) {
+			return
+		}
+
+		select {
+		case ev := <-ch:
+			assert.Fail(t, `there should be no more events`, `got %s`, ev)
+		case <-time.After(100 * time.Millisecond):
+		}
+	})
+	t.Run("Max delay", func(t *testing.T) {
+		ch := make(chan api.Event, 10)
+		sink := fsnotify.NewDebounceSink(fsnotify.ChannelEventSink(ch),
+			fsnotify.WithQuietPeriod(100*time.Millisecond),
+			fsnotify.WithMaxDelay(200*time.Millisecond),
+		)
+
+		// Keep the path busy for much longer than the maximum delay
+		start := time.Now()
+		done := make(chan struct{})
+		defer close(done)
+		go func() {
+			ticker := time.NewTicker(10 * time.Millisecond)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-done:
+					return
+				case <-ticker.C:
+					sink.Event(api.NewEvent("/foo", api.OpMask(api.OpWrite)))
+				}
+			}
+		}()
+
+		select {
+		case ev := <-ch:
+			if !assert.Equal(t, "/foo", ev.Name(), `event name should match`) {
+				return
+			}
+			if !assert.Less(t, int64(time.Since(start)), int64(time.Second), `event should be forwarded before the path goes quiet`) {
+				return
+			}
+		case <-time.After(2 * time.Second):
+			assert.Fail(t, `timed out waiting for event`)
+		}
+	})
+	t.Run("Flush", func(t *testing.T) {
+		ch := make(chan api.Event, 10)
+		sink := fsnotify.NewDebounceSink(fsnotify.ChannelEventSink(ch), fsnotify.WithQuietPeriod(time.Hour))
+
+		ev := api.NewEvent("/foo", api.OpMask(api.OpWrite))
+		sink.Event(ev)
+		sink.Flush()
+
+		select {
+		case got := <-ch:
+			if !assert.Equal(t, ev, got, `a single event should be forwarded as is`) {
+				return
+			}
+		default:
+			assert.Fail(t, `event should be forwarded by Flush()`)
+		}
+	})
+	t.Run("Marker", func(t *testing.T) {
+		ch := make(chan api.Event, 10)
+		sink := fsnotify.NewDebounceSink(fsnotify.ChannelEventSink(ch), fsnotify.WithQuietPeriod(time.Hour))
+
+		sink.Event(api.NewEvent("/foo", api.OpMask(api.OpWrite)))
+		sink.Event(api.NewEvent("", api.OpMask(api.OpOverflow)))
+		sink.Event(api.NewEvent("", api.OpMask(api.OpRestart)))
+
+		var received []string
+		for i := 0; i < 3; i++ {
+			select {
+			case ev := <-ch:
+				received = append(received, ev.String())
+			default:
+				assert.Fail(t, `marker events should not be held`)
+				return
+			}
+		}
+		assert.Equal(t, []string{
+			api.NewEvent("/foo", api.OpMask(api.OpWrite)).String(),
+			api.NewEvent("", api.OpMask(api.OpOverflow)).String(),
+			api.NewEvent("", api.OpMask(api.OpRestart)).String(),
+		}, received, `pending events should be forwarded before marker events, which are not coalesced`)
+	})
+	t.Run("Rename", func(t *testing.T) {
+		ch := make(chan api.Event, 10)
+		sink := fsnotify.NewDebounceSink(fsnotify.ChannelEventSink(ch), fsnotify.WithQuietPeriod(time.Hour))
+
+		sink.Event(api.NewRenameEvent("/bar", "/foo", api.OpMask(api.OpRename)))
+		sink.Event(api.NewEvent("/foo", api.OpMask(api.OpWrite)))
+		sink.Flush()
+
+		select {
+		case ev := <-ch:
+			rev, ok := ev.(api.RenameEvent)
+			if !assert.True(t, ok, `coalesced rename should be a rename event`) {
+				return
+			}
+			if !assert.Equal(t, "/bar", rev.OldName(), `old name should be kept`) {
+				return
+			}
+			var expected api.OpMask
+			expected.Set(api.OpRename)
+			expected.Set(api.OpWrite)
+			assert.Equal(t, expected, ev.Mask(), `masks should be merged`)
+		default:
+			assert.Fail(t, `event should be forwarded by Flush()`)
+		}
+	})
+}
@@ -0,0 +1,383 @@
+// Package drivertest provides a conformance test suite for api.Driver
+// implementations.
+//
+// A driver that passes the suite behaves like the drivers in this
+// repository, and can be used with fsnotify.Watcher. To run the suite
+// against a driver, call Run from a test:
+//
+//	func TestConformance(t *testing.T) {
+//	  drivertest.Run(t, func(t *testing.T) api.Driver {
+//	    return mydriver.New()
+//	  })
+//	}
+package drivertest
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/lestrrat-go/fsnotify/api"
+	"github.com/stretchr/testify/assert"
+)
+
+// Factory creates a new instance of the driver under test. It is
+// called once for every test, and the driver is only run once.
+type Factory func(*testing.T) api.Driver
+
+type suite struct {
+	factory Factory
+	ops     api.OpMask
+	timeout time.Duration
+}
+
+// Run runs the conformance test suite against the drivers created
+// by factory.
+func Run(t *testing.T, factory Factory, options ...RunOption) {
+	s := &suite{
+		factory: factory,
+		ops:     api.DefaultOpMask,
+		timeout: 5 * time.Second,
+	}
+	for _, option := range options {
+		//nolint:forcetypeassert
+		switch option.Ident() {
+		case identSupportedOps{}:
+			s.ops = option.Value().(api.OpMask)
+		case identTimeout{}:
+			s.timeout = option.Value().(time.Duration)
+		}
+	}
+
+	t.Run("Run", s.testRun)
+	t.Run("Add and Remove", s.testAddRemove)
+	t.Run("Errors", s.testErrors)
+	t.Run("Events", s.testEvents)
+}
+
+type chanEventSink chan api.Event
+
+func (sink chanEventSink) Event(ev api.Event) {
+	sink <- ev
+}
+
+// instance is a running driver
+type instance struct {
+	driver api.Driver
+	events chan api.Event
+	errors chan error
+	cancel context.CancelFunc
+	done   chan struct{}
+	err    error // returned by Run(), valid once done is closed
+}
+
+// start runs a new driver, and waits for it to become ready
+func (s *suite) start(t *testing.T) *instance {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	inst := &instance{
+		driver: s.factory(t),
+		events: make(chan api.Event, 256),
+		errors: make(chan error, 256),
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+
+	ready := make(chan struct{})
+	go func() {
+		defer close(inst.done)
+		inst.err = inst.driver.Run(ctx, ready, chanEventSink(inst.events), api.ChanErrSink(inst.errors))
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-inst.done
+	})
+
+	select {
+	case <-ready:
+	case <-inst.done:
+		t.Fatalf(`Run() returned before the driver became ready: %v`, inst.err)
+	case <-time.After(s.timeout):
+		t.Fatal(`driver did not become ready`)
+	}
+	return inst
+}
+
+func tempDir(t *testing.T) string {
+	t.Helper()
+
+	dir, err := ioutil.TempDir("", "fsnotify-drivertest-*")
+	if err != nil {
+		t.Fatalf(`ioutil.TempDir failed: %s`, err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	// Resolve symbolic links (e.g. /tmp on macOS), so that the
+	// names in the events can be compared with what we expect
+	dir, err = filepath.EvalSymlinks(dir)
+	if err != nil {
+		t.Fatalf(`filepath.EvalSymlinks failed: %s`, err)
+	}
+	return dir
+}
+
+func (s *suite) testRun(t *testing.T) {
+	t.Run("Ready", func(t *testing.T) {
+		s.start(t)
+	})
+	t.Run("Context cancellation", func(t *testing.T) {
+		inst := s.start(t)
+		inst.cancel()
+		select {
+		case <-inst.done:
+			assert.NoError(t, inst.err, `Run() should return nil when the context is canceled`)
+		case <-time.After(s.timeout):
+			assert.Fail(t, `Run() should return when the context is canceled`)
+		}
+	})
+}
+
+func (s *suite) testAddRemove(t *testing.T) {
+	t.Run("With ack", func(t *testing.T) {
+		dir := tempDir(t)
+		inst := s.start(t)
+		if !assert.NoError(t, inst.driver.Add(dir, api.WithAck(true)), `Add() should succeed`) {
+			return
+		}
+		if !assert.NoError(t, inst.driver.Remove(dir, api.WithAck(true)), `Remove() should succeed`) {
+			return
+		}
+	})
+	t.Run("Without ack", func(t *testing.T) {
+		dir := tempDir(t)
+		inst := s.start(t)
+		if !assert.NoError(t, inst.driver.Add(dir), `Add() should succeed`) {
+			return
+		}
+
+		// We have no way of knowing when the path is actually added,
+		// but once it is, events should be reported.
+		name := filepath.Join(dir, "file")
+		deadline := time.After(s.timeout)
+		for {
+			if !assert.NoError(t, ioutil.WriteFile(name, []byte(time.Now().String()), 0644), `ioutil.WriteFile should succeed`) {
+				return
+			}
+			select {
+			case ev := <-inst.events:
+				if !assert.Equal(t, name, ev.Name(), `event name should match`) {
+					return
+				}
+			case err := <-inst.errors:
+				assert.NoError(t, err, `there should be no errors`)
+				return
+			case <-deadline:
+				assert.Fail(t, `timed out waiting for event`)
+				return
+			case <-time.After(100 * time.Millisecond):
+				continue
+			}
+			break
+		}
+
+		if !assert.NoError(t, inst.driver.Remove(dir), `Remove() should succeed`) {
+			return
+		}
+	})
+}
+
+func (s *suite) testErrors(t *testing.T) {
+	t.Run("Add with ack", func(t *testing.T) {
+		dir := tempDir(t)
+		inst := s.start(t)
+		err := inst.driver.Add(filepath.Join(dir, "nonexistent"), api.WithAck(true))
+		if !assert.ErrorIs(t, err, api.ErrNotFound, `Add() on a nonexistent path should fail with api.ErrNotFound`) {
+			return
+		}
+		var apiErr *api.Error
+		if !assert.True(t, errors.As(err, &apiErr), `error should be an *api.Error`) {
+			return
+		}
+		if !assert.Equal(t, filepath.Join(dir, "nonexistent"), apiErr.Path, `error should carry the path`) {
+			return
+		}
+		if !assert.False(t, apiErr.Fatal, `error should not be fatal`) {
+			return
+		}
+	})
+	t.Run("Add without ack", func(t *testing.T) {
+		dir := tempDir(t)
+		inst := s.start(t)
+		if !assert.NoError(t, inst.driver.Add(filepath.Join(dir, "nonexistent")), `Add() without ack should succeed`) {
+			return
+		}
+		select {
+		case err := <-inst.errors:
+			assert.ErrorIs(t, err, api.ErrNotFound, `error should be reported to the error sink`)
+		case <-time.After(s.timeout):
+			assert.Fail(t, `timed out waiting for error`)
+		}
+	})
+	t.Run("Remove with ack", func(t *testing.T) {
+		dir := tempDir(t)
+		inst := s.start(t)
+		if !assert.ErrorIs(t, inst.driver.Remove(dir, api.WithAck(true)), api.ErrNotWatched, `Remove() on a path that is not watched should fail with api.ErrNotWatched`) {
+			return
+		}
+	})
+}
+
+// eventTest describes how to trigger a single operation on a file
+// called "file" in a directory that is being watched.
+type eventTest struct {
+	Op      api.Op
+	Fixture bool // true if the file should exist before the directory is watched
+	Action  func(name string) error
+	Name    string // name of the file in the event, if not "file"
+	OldName string // old name of the file, for api.RenameEvent
+}
+
+var eventTests = []eventTest{
+	{
+		Op: api.OpCreate,
+		Action: func(name string) error {
+			return ioutil.WriteFile(name, nil, 0644)
+		},
+	},
+	{
+		Op:      api.OpWrite,
+		Fixture: true,
+		Action: func(name string) error {
+			return appendFile(name)
+		},
+	},
+	{
+		Op:      api.OpRemove,
+		Fixture: true,
+		Action:  os.Remove,
+	},
+	{
+		Op:      api.OpRename,
+		Fixture: true,
+		Action: func(name string) error {
+			return os.Rename(name, filepath.Join(filepath.Dir(name), "renamed"))
+		},
+		Name:    "renamed",
+		OldName: "file",
+	},
+	{
+		Op:      api.OpChmod,
+		Fixture: true,
+		Action: func(name string) error {
+			return os.Chmod(name, 0600)
+		},
+	},
+	{
+		Op:      api.OpCloseWrite,
+		Fixture: true,
+		Action: func(name string) error {
+			return appendFile(name)
+		},
+	},
+	{
+		Op:      api.OpCloseNoWrite,
+		Fixture: true,
+		Action:  openClose,
+	},
+	{
+		Op:      api.OpOpen,
+		Fixture: true,
+		Action:  openClose,
+	},
+	{
+		Op:      api.OpAccess,
+		Fixture: true,
+		Action: func(name string) error {
+			_, err := ioutil.ReadFile(name)
+			return err
+		},
+	},
+}
+
+func appendFile(name string) error {
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write([]byte(`Hello, World!`)); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func openClose(name string) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+func (s *suite) testEvents(t *testing.T) {
+	for _, tc := range eventTests {
+		tc := tc
+		t.Run(tc.Op.String(), func(t *testing.T) {
+			if !s.ops.IsSet(tc.Op) {
+				t.Skipf(`%s is not supported by the driver`, tc.Op)
+			}
+
+			dir := tempDir(t)
+			name := filepath.Join(dir, "file")
+			if tc.Fixture {
+				// Reading an empty file does not count as an access
+				if !assert.NoError(t, ioutil.WriteFile(name, []byte(`Hello`), 0644), `ioutil.WriteFile should succeed`) {
+					return
+				}
+			}
+
+			inst := s.start(t)
+			// Only ask for the operation being tested, so that the first
+			// event that we receive should be the one that we are after
+			if !assert.NoError(t, inst.driver.Add(dir, api.WithOpMask(api.OpMask(tc.Op)), api.WithAck(true)), `Add() should succeed`) {
+				return
+			}
+			if !assert.NoError(t, tc.Action(name), `action should succeed`) {
+				return
+			}
+
+			expected := name
+			if tc.Name != "" {
+				expected = filepath.Join(dir, tc.Name)
+			}
+
+			select {
+			case ev := <-inst.events:
+				if !assert.Equal(t, expected, ev.Name(), `event name should match`) {
+					return
+				}
+				if !assert.Equal(t, api.OpMask(tc.Op), ev.Mask(), `event mask should only contain %s`, tc.Op) {
+					return
+				}
+				if tc.OldName != "" {
+					rev, ok := ev.(api.RenameEvent)
+					if !assert.True(t, ok, `event should be an api.RenameEvent`) {
+						return
+					}
+					if !assert.Equal(t, filepath.Join(dir, tc.OldName), rev.OldName(), `old name should match`) {
+						return
+					}
+				}
+			case err := <-inst.errors:
+				assert.NoError(t, err, `there should be no errors`)
+			case <-time.After(s.timeout):
+				assert.Fail(t, `timed out waiting for event`)
+			}
+		})
+	}
+}
@@ -0,0 +1,37 @@
+package drivertest
+
+import (
+	"time"
+
+	"github.com/lestrrat-go/fsnotify/api"
+	"github.com/lestrrat-go/option"
+)
+
+type Option = option.Interface
+type RunOption interface {
+	Option
+	runOption()
+}
+
+type runOption struct {
+	Option
+}
+
+func (*runOption) runOption() {}
+
+type identSupportedOps struct{}
+type identTimeout struct{}
+
+// WithSupportedOps specifies the operations that the driver is able to
+// report. Operations that are not in the mask are not tested.
+// The default is api.DefaultOpMask.
+func WithSupportedOps(mask api.OpMask) RunOption {
+	return &runOption{option.New(identSupportedOps{}, mask)}
+}
+
+// WithTimeout specifies how long to wait for the driver to respond
+// (become ready, report an event, etc) before failing the test.
+// The default is 5 seconds.
+func WithTimeout(d time.Duration) RunOption {
+	return &runOption{option.New(identTimeout{}, d)}
+}
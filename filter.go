@@ -0,0 +1,24 @@
+package fsnotify
+
+import (
+	"github.com/lestrrat-go/fsnotify/api"
+	"github.com/lestrrat-go/fsnotify/filter"
+)
+
+// filterSink forwards the events that pass through a filter.Filter.
+// Marker events (see api.MarkerOpMask) are always forwarded.
+type filterSink struct {
+	filter *filter.Filter
+	dst    api.EventSink
+}
+
+func (sink *filterSink) Event(ev api.Event) {
+	if ev.Mask()&api.MarkerOpMask == 0 && !sink.filter.Match(ev.Name()) {
+		// Renames are interesting if either side of them is
+		rev, ok := ev.(api.RenameEvent)
+		if !ok || !sink.filter.Match(rev.OldName()) {
+			return
+		}
+	}
+	sink.dst.Event(ev)
+}
@@ -0,0 +1,147 @@
+// Package filter implements include/exclude filtering of file names
+// using glob patterns.
+//
+// Patterns are made of elements separated by slashes. Each element
+// uses the syntax of path/filepath.Match, and the special element
+// `**` matches zero or more elements. Patterns that start with a
+// slash are anchored at the root of the file system, other patterns
+// may match starting at any level.
+//
+// A pattern matches a name if it matches the name itself, or any of
+// the directories that contain it. For example, `node_modules` matches
+// `/src/node_modules` and `/src/node_modules/foo/index.js`, `*.go`
+// matches `/src/main.go`, and `**/testdata/*.txt` matches
+// `/src/pkg/testdata/input.txt`.
+package filter
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// Filter decides which names should be let through
+type Filter struct {
+	include []pattern
+	exclude []pattern
+}
+
+type pattern []string
+
+// New creates a new Filter. An error is returned if any of the
+// patterns are malformed.
+func New(options ...NewOption) (*Filter, error) {
+	var f Filter
+	for _, option := range options {
+		var dst *[]pattern
+		switch option.Ident() {
+		case identInclude{}:
+			dst = &f.include
+		case identExclude{}:
+			dst = &f.exclude
+		default:
+			continue
+		}
+
+		//nolint:forcetypeassert
+		for _, src := range option.Value().([]string) {
+			p, err := compile(src)
+			if err != nil {
+				return nil, err
+			}
+			*dst = append(*dst, p)
+		}
+	}
+	return &f, nil
+}
+
+func compile(src string) (pattern, error) {
+	src = filepath.ToSlash(src)
+	anchored := strings.HasPrefix(src, "/")
+	var p pattern
+	if !anchored {
+		p = append(p, "**")
+	}
+	for _, elem := range strings.Split(strings.Trim(src, "/"), "/") {
+		if elem == "" {
+			continue
+		}
+		// Collapse consecutive `**`, they are redundant
+		if elem == "**" && len(p) > 0 && p[len(p)-1] == "**" {
+			continue
+		}
+		if _, err := filepath.Match(elem, ""); err != nil {
+			return nil, fmt.Errorf(`invalid pattern %q: %w`, src, err)
+		}
+		p = append(p, elem)
+	}
+	if len(p) == 0 || (len(p) == 1 && p[0] == "**") {
+		return nil, fmt.Errorf(`invalid pattern %q: empty pattern`, src)
+	}
+	return p, nil
+}
+
+func split(name string) []string {
+	return strings.Split(strings.Trim(filepath.ToSlash(name), "/"), "/")
+}
+
+// matchElems returns true if the pattern matches all of the elements
+func matchElems(p pattern, elems []string) bool {
+	for len(p) > 0 {
+		if p[0] == "**" {
+			for i := 0; i <= len(elems); i++ {
+				if matchElems(p[1:], elems[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(elems) == 0 {
+			return false
+		}
+		if ok, _ := filepath.Match(p[0], elems[0]); !ok {
+			return false
+		}
+		p = p[1:]
+		elems = elems[1:]
+	}
+	return len(elems) == 0
+}
+
+// match returns true if the pattern matches the name, or any
+// of its parent directories
+func (p pattern) match(elems []string) bool {
+	for i := 1; i <= len(elems); i++ {
+		if matchElems(p, elems[:i]) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchAny(patterns []pattern, elems []string) bool {
+	for _, p := range patterns {
+		if p.match(elems) {
+			return true
+		}
+	}
+	return false
+}
+
+// Match returns true if the name should be let through: it matches
+// one of the include patterns (if any), and none of the exclude patterns.
+func (f *Filter) Match(name string) bool {
+	elems := split(name)
+	if matchAny(f.exclude, elems) {
+		return false
+	}
+	return len(f.include) == 0 || matchAny(f.include, elems)
+}
+
+// ExcludeDir returns true if nothing in the directory can ever be let
+// through, because the directory itself is excluded. Include patterns
+// are not taken into account, as a directory that does not match them
+// may still contain names that do.
+func (f *Filter) ExcludeDir(dir string) bool {
+	return matchAny(f.exclude, split(dir))
+}
@@ -0,0 +1,58 @@
+package filter_test
+
+import (
+	"testing"
+
+	"github.com/lestrrat-go/fsnotify/filter"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFilter(t *testing.T) {
+	f, err := filter.New(
+		filter.WithInclude("*.go", "/etc/app/**/*.conf"),
+		filter.WithExclude("node_modules", "*_test.go", "**/testdata/**"),
+	)
+	if !assert.NoError(t, err, `filter.New should succeed`) {
+		return
+	}
+
+	testcases := []struct {
+		Name       string
+		Match      bool
+		ExcludeDir bool
+	}{
+		{Name: "/src/main.go", Match: true},
+		{Name: "main.go", Match: true},
+		{Name: "/src/README.md", Match: false},
+		{Name: "/src/main_test.go", Match: false, ExcludeDir: true},
+		{Name: "/src/node_modules", Match: false, ExcludeDir: true},
+		{Name: "/src/node_modules/foo/index.go", Match: false, ExcludeDir: true},
+		{Name: "/src/pkg/testdata/input.go", Match: false, ExcludeDir: true},
+		{Name: "/src/pkg/testdata", Match: false, ExcludeDir: true},
+		{Name: "/src/pkg/testdata.go", Match: true, ExcludeDir: false},
+		{Name: "/etc/app/app.conf", Match: true},
+		{Name: "/etc/app/conf.d/extra.conf", Match: true},
+		{Name: "/opt/etc/app/app.conf", Match: false},
+	}
+
+	for _, tc := range testcases {
+		tc := tc
+		t.Run(tc.Name, func(t *testing.T) {
+			if !assert.Equal(t, tc.Match, f.Match(tc.Name), `f.Match should return %t`, tc.Match) {
+				return
+			}
+			if !assert.Equal(t, tc.ExcludeDir, f.ExcludeDir(tc.Name), `f.ExcludeDir should return %t`, tc.ExcludeDir) {
+				return
+			}
+		})
+	}
+
+	t.Run("Invalid patterns", func(t *testing.T) {
+		for _, src := range []string{"[", "", "**"} {
+			_, err := filter.New(filter.WithExclude(src))
+			if !assert.Error(t, err, `filter.New(%q) should fail`, src) {
+				return
+			}
+		}
+	})
+}
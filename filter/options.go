@@ -0,0 +1,32 @@
+package filter
+
+import "github.com/lestrrat-go/option"
+
+type Option = option.Interface
+type NewOption interface {
+	Option
+	newOption()
+}
+
+type newOption struct {
+	Option
+}
+
+func (*newOption) newOption() {}
+
+type identInclude struct{}
+type identExclude struct{}
+
+// WithInclude specifies patterns for names that should be let through.
+// If no include patterns are specified, all names are let through
+// unless they are excluded. It may be specified multiple times.
+func WithInclude(patterns ...string) NewOption {
+	return &newOption{option.New(identInclude{}, patterns)}
+}
+
+// WithExclude specifies patterns for names that should be filtered out.
+// Exclusion takes precedence over inclusion. It may be specified
+// multiple times.
+func WithExclude(patterns ...string) NewOption {
+	return &newOption{option.New(identExclude{}, patterns)}
+}
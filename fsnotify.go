@@ -2,10 +2,15 @@ package fsnotify
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"path/filepath"
+	"reflect"
 	"sync"
+	"time"
 
 	"github.com/lestrrat-go/fsnotify/api"
+	"github.com/lestrrat-go/fsnotify/filter"
 )
 
 type ctrlCmdType int
@@ -13,31 +18,38 @@ type ctrlCmdType int
 const (
 	cmdAddEntry = iota + 1
 	cmdRemoveEntry
-	cmdEvent
 )
 
 type ctrlCmd struct {
-	Type int
-	Arg  interface{}
+	Type    int
+	Arg     interface{}
+	Options []CommandOption
+
+	// Reply is non-nil for commands that are waiting for the driver
+	// to acknowledge them (see AddSync and RemoveSync)
+	Reply chan error
 }
 
 type Watcher struct {
 	// The driver object behind this watcher.
 	driver api.Driver
 
-	// Hold the unique names of watch targets
-	targets map[string]struct{}
+	// Hold the unique names of watch targets, along with the
+	// options of each `Add()` call that was made for them
+	targets map[string][][]CommandOption
 
 	// list of commands that yet to be passed to the main Watch() goroutine
 	pending []*ctrlCmd
 
-	// list of unhandled events
-	events []api.Event
+	// true while Watch() is running. Protected by muPending
+	running bool
+
+	// events that are waiting to be read by Next() or TryNext()
+	queue *eventQueue
 
 	control chan *ctrlCmd
 
 	muPending *sync.RWMutex
-	muEvents  *sync.RWMutex
 	muTargets *sync.RWMutex
 	cond      *sync.Cond
 }
@@ -49,10 +61,10 @@ func Create(d api.Driver) *Watcher {
 		cond:      sync.NewCond(&muPending),
 		control:   make(chan *ctrlCmd, 1),
 		driver:    d,
-		muEvents:  &sync.RWMutex{},
 		muPending: &muPending,
 		muTargets: &sync.RWMutex{},
-		targets:   make(map[string]struct{}),
+		queue:     newEventQueue(),
+		targets:   make(map[string][][]CommandOption),
 	}
 }
 
@@ -62,7 +74,11 @@ func (w *Watcher) Driver() api.Driver {
 
 func (w *Watcher) addCmd(cmd *ctrlCmd) {
 	w.muPending.Lock()
-	w.pending = append(w.pending, cmd)
+	if w.running {
+		w.pending = append(w.pending, cmd)
+	} else {
+		w.settleIdle(cmd)
+	}
 	w.muPending.Unlock()
 	// Send a signal to the goroutine that is listening for
 	// update requests. It is important to make this a sync.Cond
@@ -74,27 +90,137 @@ func (w *Watcher) addCmd(cmd *ctrlCmd) {
 	w.cond.Signal()
 }
 
-func (w *Watcher) Add(fn string) {
-	w.muTargets.Lock()
-	_, ok := w.targets[fn]
-	if !ok {
-		w.targets[fn] = struct{}{}
+// Add adds a new watch target. The options are passed to the
+// driver, and are remembered so that the target can be re-added
+// with the same options should the driver be restarted.
+//
+// For example, to watch a directory tree, use
+//
+//	w.Add(dir, fsnotify.WithRecursive(true))
+//
+// Adding the same target multiple times with different options
+// merges the options, e.g. the operations specified with
+// fsnotify.WithOpMask() are combined. Adding it again with the same
+// options has no effect.
+func (w *Watcher) Add(fn string, options ...CommandOption) {
+	if w.remember(fn, options) {
+		w.add(fn, options)
 	}
-	w.muTargets.Unlock()
+}
 
-	if !ok {
-		w.add(fn)
+// remember records the options of an `Add()` call for fn, and returns
+// false if they are already known
+func (w *Watcher) remember(fn string, options []CommandOption) bool {
+	w.muTargets.Lock()
+	defer w.muTargets.Unlock()
+
+	calls, ok := w.targets[fn]
+	if ok && len(options) == 0 {
+		return false
+	}
+	for _, other := range calls {
+		if sameOptions(other, options) {
+			return false
+		}
 	}
+	w.targets[fn] = append(calls, options)
+	return true
 }
 
 // factored out so that it can be used elsewhere
-func (w *Watcher) add(fn string) {
+func (w *Watcher) add(fn string, options []CommandOption) {
 	w.addCmd(&ctrlCmd{
-		Type: cmdAddEntry,
-		Arg:  fn,
+		Type:    cmdAddEntry,
+		Arg:     fn,
+		Options: options,
 	})
 }
 
+// AddSync adds a new watch target in the same way as Add, but waits
+// until the driver has acknowledged the command, and returns the error
+// reported by the driver, if any. If the driver fails to watch the
+// target, it is forgotten as if Add had never been called.
+//
+// If Watch is not running, AddSync waits until it is started and the
+// target has been added to the driver, or until ctx is done. If Watch
+// stops before the driver acknowledged the command, ErrWatchStopped is
+// returned, but the target is still added when Watch is started again.
+// Other errors come from the driver.
+func (w *Watcher) AddSync(ctx context.Context, fn string, options ...CommandOption) error {
+	appended := w.remember(fn, options)
+
+	reply := make(chan error, 1)
+	w.addCmd(&ctrlCmd{
+		Type:    cmdAddEntry,
+		Arg:     fn,
+		Options: options,
+		Reply:   reply,
+	})
+
+	var err error
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case err = <-reply:
+	}
+
+	if err != nil && !errors.Is(err, ErrWatchStopped) && appended {
+		w.forget(fn, options)
+	}
+	return err
+}
+
+// forget removes the options of a failed `Add()` call for fn
+func (w *Watcher) forget(fn string, options []CommandOption) {
+	w.muTargets.Lock()
+	defer w.muTargets.Unlock()
+
+	calls := w.targets[fn]
+	for i := len(calls) - 1; i >= 0; i-- {
+		if sameOptions(calls[i], options) {
+			calls = append(calls[:i], calls[i+1:]...)
+			break
+		}
+	}
+	if len(calls) == 0 {
+		delete(w.targets, fn)
+	} else {
+		w.targets[fn] = calls
+	}
+}
+
+func sameOptions(a, b []CommandOption) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !sameOption(a[i], b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// sameOption returns true if a and b are the same option with the same
+// value. Values that cannot be compared, such as functions, are only
+// the same if they come from the same option.
+func sameOption(a, b CommandOption) bool {
+	if a == b {
+		return true
+	}
+	if a.Ident() != b.Ident() {
+		return false
+	}
+	va, vb := a.Value(), b.Value()
+	if va == nil || vb == nil {
+		return va == vb
+	}
+	if reflect.TypeOf(va) != reflect.TypeOf(vb) || !reflect.TypeOf(va).Comparable() {
+		return false
+	}
+	return va == vb
+}
+
 func (w *Watcher) Remove(fn string) {
 	w.muTargets.Lock()
 	_, ok := w.targets[fn]
@@ -111,6 +237,81 @@ func (w *Watcher) Remove(fn string) {
 	}
 }
 
+// RemoveSync removes a watch target in the same way as Remove, but
+// waits until the driver has acknowledged the command, and returns the
+// error reported by the driver, if any. Removing a target that was
+// never added is an error.
+//
+// If Watch is not running, the target is simply forgotten, and
+// RemoveSync returns immediately. If Watch stops before the driver
+// acknowledged the command, ErrWatchStopped is returned, and the target
+// is forgotten all the same.
+func (w *Watcher) RemoveSync(ctx context.Context, fn string) error {
+	w.muTargets.Lock()
+	_, ok := w.targets[fn]
+	if ok {
+		delete(w.targets, fn)
+	}
+	w.muTargets.Unlock()
+
+	if !ok {
+		return fmt.Errorf(`failed to remove %q: %w`, fn, api.ErrNotWatched)
+	}
+
+	reply := make(chan error, 1)
+	w.addCmd(&ctrlCmd{
+		Type:  cmdRemoveEntry,
+		Arg:   fn,
+		Reply: reply,
+	})
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case err := <-reply:
+		return err
+	}
+}
+
+// settleIdle deals with a command while Watch is not running. The
+// targets are added when Watch starts, so most commands can simply be
+// dropped. Only commands that wait for the driver to add a target are
+// kept until then. w.muPending must be held by the caller.
+func (w *Watcher) settleIdle(cmd *ctrlCmd) {
+	if cmd.Reply == nil {
+		return
+	}
+	switch cmd.Type {
+	case cmdAddEntry:
+		w.pending = append(w.pending, cmd)
+	case cmdRemoveEntry:
+		// There is no driver to remove the target from, and adds that
+		// were waiting for it have nothing left to do
+		pending := w.pending[:0]
+		for _, other := range w.pending {
+			if other.Type == cmdAddEntry && other.Arg == cmd.Arg {
+				other.Reply <- nil
+				continue
+			}
+			pending = append(pending, other)
+		}
+		w.pending = pending
+		cmd.Reply <- nil
+	}
+}
+
+// targetInfos returns the summary of the options for each target
+func (w *Watcher) targetInfos() map[string]targetInfo {
+	w.muTargets.RLock()
+	defer w.muTargets.RUnlock()
+
+	infos := make(map[string]targetInfo, len(w.targets))
+	for fn, calls := range w.targets {
+		infos[filepath.Clean(fn)] = newTargetInfo(calls)
+	}
+	return infos
+}
+
 func (w *Watcher) processPendingCmds(ctx context.Context) {
 	for {
 		select {
@@ -121,6 +322,10 @@ func (w *Watcher) processPendingCmds(ctx context.Context) {
 
 		w.muPending.Lock()
 		for len(w.pending) == 0 {
+			if ctx.Err() != nil {
+				w.muPending.Unlock()
+				return
+			}
 			w.cond.Wait()
 		}
 		w.muPending.Unlock()
@@ -139,6 +344,7 @@ func (w *Watcher) processPendingCmds(ctx context.Context) {
 
 			select {
 			case <-ctx.Done():
+				w.requeue(cmd)
 				return
 			case w.control <- cmd:
 			}
@@ -146,54 +352,264 @@ func (w *Watcher) processPendingCmds(ctx context.Context) {
 	}
 }
 
-func (w *Watcher) clearPending() {
+// requeue puts back a command that could not be handled
+func (w *Watcher) requeue(cmd *ctrlCmd) {
 	w.muPending.Lock()
-	w.pending = nil
+	defer w.muPending.Unlock()
+	if w.running {
+		w.pending = append([]*ctrlCmd{cmd}, w.pending...)
+	} else {
+		w.settleIdle(cmd)
+	}
+}
+
+func (w *Watcher) start() {
+	w.muPending.Lock()
+	w.running = true
 	w.muPending.Unlock()
 }
 
+// stop marks the watcher as not running, and deals with the
+// commands that have not been handled. Callers that are waiting for
+// the driver are released with ErrWatchStopped. The targets of the
+// other commands are taken care of when Watch is started again.
+func (w *Watcher) stop() {
+	w.muPending.Lock()
+	defer w.muPending.Unlock()
+	w.running = false
+
+	var pending []*ctrlCmd
+	for len(w.control) > 0 {
+		pending = append(pending, <-w.control)
+	}
+	pending = append(pending, w.pending...)
+	w.pending = nil
+	for _, cmd := range pending {
+		if cmd.Reply != nil {
+			cmd.Reply <- ErrWatchStopped
+		}
+	}
+}
+
 // Watch starts the watcher. By default it watches in the foreground,
 // therefore if you would like this to run in the background you should
 // execute it as a separate goroutine.
-func (w *Watcher) Watch(ctx context.Context, options ...WatchOption) {
-	defer w.clearPending()
+//
+// Watch returns when ctx is done, in which case ctx.Err() is returned,
+// or when the driver fails. In the latter case the error reported by
+// the driver is returned, and ErrDriverStopped is returned if the
+// driver stopped without giving a reason. With WithRestart, the driver
+// is restarted instead, and Watch only returns when ctx is done.
+//
+// Unless an event sink is specified using WithEventSink, the events
+// are queued so that they can be read using Next and TryNext.
+func (w *Watcher) Watch(ctx context.Context, options ...WatchOption) (err error) {
+	w.start()
+	defer w.stop()
+
+	// Everything that is started here must stop when Watch returns,
+	// including when the driver fails
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
 
 	// Unpack the options.
 	var errSink api.ErrorSink = api.NilSink{}
-	var evSink api.EventSink = api.NilSink{}
+	var evSink api.EventSink
+	var f *filter.Filter
+	var checkpointPath string
+	var restart, rescan bool
+	checkpointInterval := defaultCheckpointInterval
+	queueSize := defaultEventQueueSize
+	restartDelay := defaultRestartDelay
+	maxRestartDelay := defaultMaxRestartDelay
 	for _, option := range options {
 		switch option.Ident() {
 		case identErrorSink{}:
 			errSink = option.Value().(api.ErrorSink)
 		case identEventSink{}:
 			evSink = option.Value().(api.EventSink)
+		case identFilter{}:
+			f = option.Value().(*filter.Filter)
+		case identCheckpoint{}:
+			checkpointPath = option.Value().(string)
+		case identCheckpointInterval{}:
+			checkpointInterval = option.Value().(time.Duration)
+		case identRestart{}:
+			restart = option.Value().(bool)
+		case identRestartDelay{}:
+			restartDelay = option.Value().(time.Duration)
+		case identMaxRestartDelay{}:
+			maxRestartDelay = option.Value().(time.Duration)
+		case identRescan{}:
+			rescan = option.Value().(bool)
+		case identEventQueueSize{}:
+			queueSize = option.Value().(int)
+		}
+	}
+
+	if evSink == nil {
+		if queueSize < 1 {
+			queueSize = defaultEventQueueSize
+		}
+		w.queue.start(queueSize)
+		evSink = w.queue
+		defer func() { w.queue.stop(err) }()
+	}
+
+	// Options that are appended to every add command sent to the
+	// driver during this session
+	var addOptions []CommandOption
+	var exclude func(string) bool
+	if f != nil {
+		evSink = &filterSink{filter: f, dst: evSink}
+		exclude = f.ExcludeDir
+		addOptions = append(addOptions, api.WithExcludeDir(exclude))
+	}
+
+	var cp *checkpointer
+	if checkpointPath != "" || (restart && rescan) {
+		cp = &checkpointer{
+			watcher:  w,
+			path:     checkpointPath,
+			interval: checkpointInterval,
+			exclude:  exclude,
+			evSink:   evSink,
+			errSink:  errSink,
 		}
 	}
 
 	// This is used to notify THIS goroutine about user
 	// commands being queued.
-	go w.processPendingCmds(ctx)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		w.processPendingCmds(ctx)
+	}()
 
 	// Make sure to wake up the above goroutine once when we exit,
-	// so it can clean after itself
-	defer w.cond.Signal()
+	// so it can clean after itself, and wait for it so that no
+	// command is sent to w.control after stop() drained it
+	defer func() {
+		cancel()
+		w.muPending.Lock()
+		w.cond.Broadcast()
+		w.muPending.Unlock()
+		wg.Wait()
+	}()
+
+	s := &session{
+		errSink:    errSink,
+		evSink:     evSink,
+		addOptions: addOptions,
+		cp:         cp,
+	}
+	delay := restartDelay
+	for {
+		readyAt, err := w.runDriver(ctx, s)
+		err = driverStopped(ctx, err)
+		if !restart || ctx.Err() != nil {
+			return err
+		}
+
+		// Remember the state at the beginning of the gap. If the driver
+		// never became ready, the state from the last failure still holds
+		if cp != nil && cp.path == "" && !readyAt.IsZero() {
+			cp.save()
+		}
+		errSink.Error(err)
+
+		if !readyAt.IsZero() && time.Since(readyAt) > maxRestartDelay {
+			delay = restartDelay
+		}
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+		if delay *= 2; delay > maxRestartDelay {
+			delay = maxRestartDelay
+		}
+		s.restarted = true
+	}
+}
+
+// session holds what is needed to run the driver during a call to Watch
+type session struct {
+	errSink    api.ErrorSink
+	evSink     api.EventSink
+	addOptions []CommandOption
+	cp         *checkpointer
+	restarted  bool
+}
+
+// runDriver runs the driver, and handles user commands until the driver
+// stops or ctx is done. It returns the time when the driver became
+// ready, which is zero if it never did, and the error that was returned
+// by the driver.
+func (w *Watcher) runDriver(ctx context.Context, s *session) (time.Time, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// With checkpoints, live events are held until the changes since
+	// the last checkpoint have been reported
+	var driverSink api.EventSink = s.evSink
+	if s.cp != nil {
+		s.cp.gate = &gateSink{dst: s.evSink}
+		driverSink = s.cp.gate
+	}
+	driverSink = &moveSink{watcher: w, dst: driverSink}
 
 	// Let the driver do its thing, and watch the events.
 	// The second argument is the data sink
+	var readyAt time.Time
 	ready := make(chan struct{})
-	go w.driver.Run(ctx, ready, evSink, errSink)
+	runErr := make(chan error, 1)
+	go func() {
+		runErr <- w.driver.Run(ctx, ready, driverSink, s.errSink)
+	}()
+
+	select {
+	case <-ctx.Done():
+		return readyAt, nil
+	case err := <-runErr:
+		return readyAt, err
+	case <-ready:
+	}
+	readyAt = time.Now()
 
-	<-ready
+	if s.restarted {
+		s.evSink.Event(api.NewEvent("", api.OpMask(api.OpRestart)))
+	}
 
-	// re-add targets. The driver could have been restarted
-	// after it has been initialized once. This process assures that the
-	// user doesn't have to re-add everything, while keeping the API
-	// completely detached from how the Driver stores this data
-	w.muTargets.Lock()
-	for fn := range w.targets {
-		w.add(fn)
+	// re-add targets. The driver may have been restarted after it
+	// failed, or Watch may have been called before. This process assures
+	// that the user doesn't have to re-add everything, while keeping the
+	// API completely detached from how the Driver stores this data
+	if s.cp != nil {
+		// The checkpointer needs to know when the targets are being
+		// watched, so it adds them itself
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.cp.run(ctx, s.addOptions)
+		}()
+		defer func() {
+			cancel()
+			wg.Wait()
+		}()
+	} else {
+		w.muTargets.Lock()
+		for fn, calls := range w.targets {
+			for _, options := range calls {
+				w.add(fn, options)
+			}
+		}
+		w.muTargets.Unlock()
 	}
-	w.muTargets.Unlock()
 
 	// Let the command queue know that we're ready, just to make sure
 	// everything that was done while we were idle is flushed
@@ -203,34 +619,135 @@ func (w *Watcher) Watch(ctx context.Context, options ...WatchOption) {
 	for {
 		select {
 		case <-ctx.Done():
-			return
+			return readyAt, nil
+		case err := <-runErr:
+			return readyAt, err
 		case cmd := <-w.control:
-			if err := w.handleControlCmd(ctx, cmd); err != nil {
-				errSink.Error(err)
+			err := w.handleControlCmd(ctx, cmd, s.addOptions)
+			if cmd.Reply != nil {
+				cmd.Reply <- err
+				continue
+			}
+			if err != nil {
+				s.errSink.Error(err)
+			}
+		}
+	}
+}
+
+// moveSink keeps track of targets that follow their files across
+// renames (see WithFollowInode). The drivers always report these
+// renames, which are only forwarded if they were requested.
+type moveSink struct {
+	watcher *Watcher
+	dst     api.EventSink
+}
+
+func (sink *moveSink) Event(ev api.Event) {
+	if rev, ok := ev.(api.RenameEvent); ok {
+		if info, moved := sink.watcher.moveTarget(rev.OldName(), rev.Name()); moved && !info.ops.IsSet(api.OpRename) {
+			return
+		}
+	}
+	sink.dst.Event(ev)
+}
+
+// moveTarget renames the target oldName to newName, if it follows its
+// file across renames. It returns the summary of the options of the
+// target, and whether it was renamed.
+func (w *Watcher) moveTarget(oldName, newName string) (targetInfo, bool) {
+	w.muTargets.Lock()
+	defer w.muTargets.Unlock()
+
+	for fn, calls := range w.targets {
+		if filepath.Clean(fn) != oldName || !followsInode(calls) {
+			continue
+		}
+		// The file may have replaced another target, which the driver
+		// no longer watches
+		delete(w.targets, fn)
+		w.targets[newName] = calls
+		return newTargetInfo(calls), true
+	}
+	return targetInfo{}, false
+}
+
+func followsInode(calls [][]CommandOption) bool {
+	for _, options := range calls {
+		for _, option := range options {
+			//nolint:forcetypeassert
+			if api.IsFollowInode(option.Ident()) && option.Value().(bool) {
+				return true
 			}
 		}
 	}
+	return false
 }
 
-func (w *Watcher) handleControlCmd(ctx context.Context, cmd *ctrlCmd) error {
+func (w *Watcher) handleControlCmd(ctx context.Context, cmd *ctrlCmd, addOptions []CommandOption) error {
 	switch cmd.Type {
 	case cmdAddEntry:
 		//nolint:forcetypeassert
 		name := cmd.Arg.(string)
 		name = filepath.Clean(name)
-		return w.driver.Add(name)
+		options := append(append([]CommandOption(nil), cmd.Options...), addOptions...)
+		if cmd.Reply != nil {
+			options = append(options, api.WithAck(true), api.WithContext(ctx))
+			return callSync(ctx, func() error { return w.driver.Add(name, options...) })
+		}
+		return w.driver.Add(name, options...)
 	case cmdRemoveEntry:
 		//nolint:forcetypeassert
 		name := cmd.Arg.(string)
 		name = filepath.Clean(name)
+		if cmd.Reply != nil {
+			return callSync(ctx, func() error { return w.driver.Remove(name, api.WithAck(true), api.WithContext(ctx)) })
+		}
 		return w.driver.Remove(name)
 	default:
-		//nolint:forcetypeassert
-		ev := cmd.Arg.(api.Event)
-		// add the event to the events queue
-		w.muEvents.Lock()
-		w.events = append(w.events, ev)
-		w.muEvents.Unlock()
 		return nil
 	}
 }
+
+const (
+	defaultRestartDelay    = time.Second
+	defaultMaxRestartDelay = time.Minute
+)
+
+// ErrDriverStopped is returned by Watch when the driver stopped
+// running without reporting an error
+var ErrDriverStopped = errors.New(`driver stopped unexpectedly`)
+
+// ErrWatchStopped is returned by AddSync and RemoveSync when Watch
+// stopped before the driver acknowledged the command
+var ErrWatchStopped = errors.New(`watch stopped before the driver acknowledged the command`)
+
+// driverStopped returns the error that Watch should return after
+// the driver returned err
+func driverStopped(ctx context.Context, err error) error {
+	// The driver may have noticed that ctx is done before we did
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if err == nil {
+		return ErrDriverStopped
+	}
+	return err
+}
+
+// callSync calls fn, which is expected to wait for the driver to
+// acknowledge a command, and returns its result. If ctx is done
+// before that, ErrWatchStopped is returned. fn should pass ctx to the
+// driver using api.WithContext, so that it does not wait any longer.
+func callSync(ctx context.Context, fn func() error) error {
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- fn()
+	}()
+	select {
+	case <-ctx.Done():
+		return fmt.Errorf(`%w: %s`, ErrWatchStopped, ctx.Err())
+	case err := <-errCh:
+		return err
+	}
+}
@@ -0,0 +1,77 @@
+//go:build linux
+// +build linux
+
+package fsnotify_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/lestrrat-go/fsnotify"
+	"github.com/lestrrat-go/fsnotify/api"
+	"github.com/lestrrat-go/fsnotify/inotify"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFollowInodeTarget(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fsnotify-test-*")
+	if !assert.NoError(t, err, `ioutil.TempDir should succeed`) {
+		return
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	if !assert.NoError(t, os.Mkdir(filepath.Join(dir, "other"), 0755), `os.Mkdir should succeed`) {
+		return
+	}
+	name := filepath.Join(dir, "file")
+	if !assert.NoError(t, ioutil.WriteFile(name, nil, 0644), `ioutil.WriteFile should succeed`) {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	driver := inotify.New()
+	watcher := fsnotify.Create(driver)
+	eventCh := make(chan api.Event, 10)
+	go watcher.Watch(ctx, fsnotify.WithEventSink(fsnotify.ChannelEventSink(eventCh)))
+
+	// Renames are not requested, but the target still follows the file
+	if !assert.NoError(t, watcher.AddSync(ctx, name, fsnotify.WithFollowInode(true), fsnotify.WithOpMask(api.OpMask(api.OpWrite))), `AddSync should succeed`) {
+		return
+	}
+
+	moved := filepath.Join(dir, "other", "moved")
+	if !assert.NoError(t, os.Rename(name, moved), `os.Rename should succeed`) {
+		return
+	}
+	if !assert.NoError(t, ioutil.WriteFile(moved, []byte(`Hello`), 0644), `ioutil.WriteFile should succeed`) {
+		return
+	}
+	select {
+	case ev := <-eventCh:
+		if !assert.Equal(t, api.NewEvent(moved, api.OpMask(api.OpWrite)), ev, `only the requested operations should be reported`) {
+			return
+		}
+	case <-ctx.Done():
+		assert.Fail(t, `timed out waiting for event`)
+		return
+	}
+
+	if !assert.ErrorIs(t, watcher.RemoveSync(ctx, name), api.ErrNotWatched, `the target should no longer be known by its old name`) {
+		return
+	}
+	if !assert.NoError(t, watcher.RemoveSync(ctx, moved), `the target should be removed by its new name`) {
+		return
+	}
+
+	usage, err := driver.Usage()
+	if !assert.NoError(t, err, `driver.Usage should succeed`) {
+		return
+	}
+	assert.Equal(t, 0, usage.Watches, `no watches should be left`)
+}
@@ -3,6 +3,8 @@
 
 package fsnotify
 
+import "github.com/lestrrat-go/fsnotify/api"
+
 func New() *Watcher {
-	return Create(NewNullDriver())
+	return Create(api.NullDriver{})
 }
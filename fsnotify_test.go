@@ -2,14 +2,18 @@ package fsnotify_test
 
 import (
 	"context"
+	"errors"
 	"io/ioutil"
 	"log"
 	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
 	"github.com/lestrrat-go/fsnotify"
 	"github.com/lestrrat-go/fsnotify/api"
+	"github.com/lestrrat-go/fsnotify/filter"
+	"github.com/lestrrat-go/fsnotify/fsnotifytest"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -110,7 +114,406 @@ func TestWatcher(t *testing.T) {
 	}
 }
 
-func ExampleInotify() {
+func TestWatcherWithFakeDriver(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	driver := fsnotifytest.NewDriver()
+	watcher := fsnotify.Create(driver)
+
+	eventCh := make(chan api.Event)
+	go watcher.Watch(ctx, fsnotify.WithEventSink(fsnotify.ChannelEventSink(eventCh)))
+
+	watcher.Add("/foo/bar", fsnotify.WithRecursive(true))
+	if !assert.NoError(t, driver.WaitForTarget(ctx, "/foo/bar"), `target should be added to the driver`) {
+		return
+	}
+
+	ev := api.NewEvent("/foo/bar/baz", api.OpMask(api.OpCreate))
+	go driver.SendEvent(ctx, ev)
+	select {
+	case got := <-eventCh:
+		if !assert.Equal(t, ev, got, `event should be delivered`) {
+			return
+		}
+	case <-ctx.Done():
+		assert.Fail(t, `timed out waiting for event`)
+		return
+	}
+
+	watcher.Remove("/foo/bar")
+	if !assert.NoError(t, driver.WaitForRemoval(ctx, "/foo/bar"), `target should be removed from the driver`) {
+		return
+	}
+}
+
+func TestSyncCommands(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	driver := fsnotifytest.NewDriver()
+	watcher := fsnotify.Create(driver)
+
+	t.Run("Before Watch", func(t *testing.T) {
+		watcher.Add("/foo")
+		if !assert.NoError(t, watcher.RemoveSync(ctx, "/foo"), `RemoveSync should not wait while Watch is not running`) {
+			return
+		}
+		if !assert.ErrorIs(t, watcher.RemoveSync(ctx, "/foo"), api.ErrNotWatched, `RemoveSync on a removed target should fail`) {
+			return
+		}
+
+		// AddSync waits until Watch is started
+		errCh := make(chan error, 1)
+		go func() { errCh <- watcher.AddSync(ctx, "/foo") }()
+		select {
+		case err := <-errCh:
+			assert.Fail(t, `AddSync should wait for Watch`, `got %v`, err)
+			return
+		case <-time.After(100 * time.Millisecond):
+		}
+
+		go watcher.Watch(ctx)
+		select {
+		case err := <-errCh:
+			if !assert.NoError(t, err, `AddSync should succeed`) {
+				return
+			}
+		case <-ctx.Done():
+			assert.Fail(t, `timed out waiting for AddSync`)
+			return
+		}
+		if !assert.Contains(t, driver.Targets(), "/foo", `target should be added to the driver`) {
+			return
+		}
+	})
+	t.Run("Add failure", func(t *testing.T) {
+		driver.FailAdd("/bar", api.NewError("fsnotifytest", "add", "/bar", api.ErrNotFound))
+		if !assert.ErrorIs(t, watcher.AddSync(ctx, "/bar"), api.ErrNotFound, `AddSync should return the driver error`) {
+			return
+		}
+		// The failed target is forgotten, so removing it fails
+		if !assert.ErrorIs(t, watcher.RemoveSync(ctx, "/bar"), api.ErrNotWatched, `RemoveSync on a failed target should fail`) {
+			return
+		}
+	})
+	t.Run("Remove", func(t *testing.T) {
+		if !assert.NoError(t, watcher.RemoveSync(ctx, "/foo"), `RemoveSync should succeed`) {
+			return
+		}
+		if !assert.NotContains(t, driver.Targets(), "/foo", `target should be removed from the driver`) {
+			return
+		}
+	})
+	t.Run("Same options", func(t *testing.T) {
+		for i := 0; i < 3; i++ {
+			watcher.Add("/baz", fsnotify.WithOpMask(api.OpMask(api.OpWrite)))
+		}
+		// Commands are handled in order
+		if !assert.NoError(t, watcher.AddSync(ctx, "/qux"), `AddSync should succeed`) {
+			return
+		}
+
+		var count int
+		for _, call := range driver.Calls() {
+			if call.Type == fsnotifytest.CallAdd && call.Path == "/baz" {
+				count++
+			}
+		}
+		assert.Equal(t, 1, count, `adding a target again with the same options should have no effect`)
+	})
+}
+
+func TestSyncCommandsWatchStopped(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// The driver never becomes ready, so the command is never handled
+	driver := fsnotifytest.NewDriver(fsnotifytest.WithManualReady(true))
+	watcher := fsnotify.Create(driver)
+
+	watchCtx, watchCancel := context.WithCancel(ctx)
+	defer watchCancel()
+	watchDone := make(chan error, 1)
+	go func() { watchDone <- watcher.Watch(watchCtx) }()
+	if !assert.NoError(t, driver.WaitRunning(ctx), `driver should be running`) {
+		return
+	}
+
+	errCh := make(chan error, 1)
+	go func() { errCh <- watcher.AddSync(ctx, "/foo") }()
+	time.Sleep(100 * time.Millisecond)
+	watchCancel()
+	<-watchDone
+
+	select {
+	case err := <-errCh:
+		if !assert.ErrorIs(t, err, fsnotify.ErrWatchStopped, `AddSync should report that Watch stopped`) {
+			return
+		}
+	case <-ctx.Done():
+		assert.Fail(t, `AddSync should return when Watch stops`)
+	}
+}
+
+// failingDriver fails to start
+type failingDriver struct {
+	api.NullDriver
+	err error
+}
+
+func (d failingDriver) Run(context.Context, chan struct{}, api.EventSink, api.ErrorSink) error {
+	return d.err
+}
+
+func TestWatchResult(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	watch := func(ctx context.Context, watcher *fsnotify.Watcher) <-chan error {
+		errCh := make(chan error, 1)
+		go func() { errCh <- watcher.Watch(ctx) }()
+		return errCh
+	}
+
+	t.Run("Context canceled", func(t *testing.T) {
+		driver := fsnotifytest.NewDriver()
+		watchCtx, watchCancel := context.WithCancel(ctx)
+		defer watchCancel()
+		errCh := watch(watchCtx, fsnotify.Create(driver))
+		if !assert.NoError(t, driver.WaitRunning(ctx), `driver should be running`) {
+			return
+		}
+		watchCancel()
+		select {
+		case err := <-errCh:
+			if !assert.ErrorIs(t, err, context.Canceled, `Watch should return the context error`) {
+				return
+			}
+		case <-ctx.Done():
+			assert.Fail(t, `timed out waiting for Watch`)
+		}
+	})
+	t.Run("Driver failure", func(t *testing.T) {
+		driver := fsnotifytest.NewDriver()
+		errCh := watch(ctx, fsnotify.Create(driver))
+
+		failure := &api.Error{Driver: "fsnotifytest", Op: "read", Fatal: true, Err: errors.New(`failure`)}
+		if !assert.NoError(t, driver.Fail(ctx, failure), `driver.Fail should succeed`) {
+			return
+		}
+		select {
+		case err := <-errCh:
+			if !assert.Equal(t, failure, err, `Watch should return the driver error`) {
+				return
+			}
+			if !assert.True(t, api.IsFatal(err), `error should be fatal`) {
+				return
+			}
+		case <-ctx.Done():
+			assert.Fail(t, `timed out waiting for Watch`)
+		}
+	})
+	t.Run("Startup failure", func(t *testing.T) {
+		failure := errors.New(`failure`)
+		select {
+		case err := <-watch(ctx, fsnotify.Create(failingDriver{err: failure})):
+			if !assert.Equal(t, failure, err, `Watch should return the driver error`) {
+				return
+			}
+		case <-ctx.Done():
+			assert.Fail(t, `timed out waiting for Watch`)
+		}
+	})
+	t.Run("Driver stopped", func(t *testing.T) {
+		select {
+		case err := <-watch(ctx, fsnotify.Create(failingDriver{})):
+			if !assert.ErrorIs(t, err, fsnotify.ErrDriverStopped, `Watch should report that the driver stopped`) {
+				return
+			}
+		case <-ctx.Done():
+			assert.Fail(t, `timed out waiting for Watch`)
+		}
+	})
+}
+
+func TestRestart(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// fail makes the driver fail, and checks that the failure is reported
+	fail := func(t *testing.T, driver *fsnotifytest.Driver, errCh chan error) bool {
+		t.Helper()
+		failure := errors.New(`failure`)
+		if !assert.NoError(t, driver.Fail(ctx, failure), `driver.Fail should succeed`) {
+			return false
+		}
+		select {
+		case err := <-errCh:
+			return assert.Equal(t, failure, err, `driver failure should be sent to the error sink`)
+		case <-ctx.Done():
+			return assert.Fail(t, `timed out waiting for error`)
+		}
+	}
+
+	expectEvent := func(t *testing.T, eventCh chan api.Event, name string, op api.Op) bool {
+		t.Helper()
+		select {
+		case ev := <-eventCh:
+			return assert.Equal(t, name, ev.Name(), `event name should match`) &&
+				assert.Equal(t, api.OpMask(op), ev.Mask(), `event mask should match`)
+		case <-ctx.Done():
+			return assert.Fail(t, `timed out waiting for event`, `expected %s for %q`, op, name)
+		}
+	}
+
+	t.Run("Restart", func(t *testing.T) {
+		driver := fsnotifytest.NewDriver()
+		watcher := fsnotify.Create(driver)
+
+		eventCh := make(chan api.Event, 16)
+		errCh := make(chan error, 16)
+		go watcher.Watch(ctx,
+			fsnotify.WithEventSink(fsnotify.ChannelEventSink(eventCh)),
+			fsnotify.WithErrorSink(fsnotify.ChannelErrorSink(errCh)),
+			fsnotify.WithRestart(true),
+			fsnotify.WithRestartDelay(10*time.Millisecond),
+		)
+
+		watcher.Add("/foo")
+		if !assert.NoError(t, driver.WaitForTarget(ctx, "/foo"), `target should be added to the driver`) {
+			return
+		}
+		if !fail(t, driver, errCh) {
+			return
+		}
+		if !expectEvent(t, eventCh, "", api.OpRestart) {
+			return
+		}
+
+		addCount := func(d *fsnotifytest.Driver) bool {
+			var count int
+			for _, call := range d.Calls() {
+				if call.Type == fsnotifytest.CallAdd && call.Path == "/foo" {
+					count++
+				}
+			}
+			return count == 2
+		}
+		if !assert.NoError(t, driver.WaitFor(ctx, addCount), `target should be re-added after the restart`) {
+			return
+		}
+	})
+	t.Run("Rescan", func(t *testing.T) {
+		dir, err := ioutil.TempDir("", "fsnotify-test-*")
+		if !assert.NoError(t, err, `ioutil.TempDir should succeed`) {
+			return
+		}
+		t.Cleanup(func() { os.RemoveAll(dir) })
+
+		driver := fsnotifytest.NewDriver()
+		watcher := fsnotify.Create(driver)
+
+		eventCh := make(chan api.Event, 16)
+		errCh := make(chan error, 16)
+		go watcher.Watch(ctx,
+			fsnotify.WithEventSink(fsnotify.ChannelEventSink(eventCh)),
+			fsnotify.WithErrorSink(fsnotify.ChannelErrorSink(errCh)),
+			fsnotify.WithRestart(true),
+			fsnotify.WithRestartDelay(10*time.Millisecond),
+			fsnotify.WithRescan(true),
+		)
+
+		watcher.Add(dir)
+		if !assert.NoError(t, driver.WaitForTarget(ctx, dir), `target should be added to the driver`) {
+			return
+		}
+		if !fail(t, driver, errCh) {
+			return
+		}
+
+		// The driver is not running, so nothing reports this
+		name := filepath.Join(dir, "file")
+		if !assert.NoError(t, ioutil.WriteFile(name, []byte(`Hello, World!`), 0600), `ioutil.WriteFile should succeed`) {
+			return
+		}
+		if !expectEvent(t, eventCh, "", api.OpRestart) {
+			return
+		}
+		if !expectEvent(t, eventCh, name, api.OpCreate) {
+			return
+		}
+	})
+}
+
+func TestWatcherWithFilter(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	f, err := filter.New(
+		filter.WithInclude("*.go"),
+		filter.WithExclude("vendor"),
+	)
+	if !assert.NoError(t, err, `filter.New should succeed`) {
+		return
+	}
+
+	driver := fsnotifytest.NewDriver()
+	watcher := fsnotify.Create(driver)
+
+	eventCh := make(chan api.Event)
+	go watcher.Watch(ctx,
+		fsnotify.WithEventSink(fsnotify.ChannelEventSink(eventCh)),
+		fsnotify.WithFilter(f),
+	)
+
+	watcher.Add("/src", fsnotify.WithRecursive(true))
+	if !assert.NoError(t, driver.WaitForTarget(ctx, "/src"), `target should be added to the driver`) {
+		return
+	}
+
+	t.Run("Exclude function is passed to the driver", func(t *testing.T) {
+		var exclude func(string) bool
+		for _, call := range driver.Calls() {
+			for _, option := range call.Options {
+				if api.IsExcludeDir(option.Ident()) {
+					exclude = option.Value().(func(string) bool)
+				}
+			}
+		}
+		if !assert.NotNil(t, exclude, `api.WithExcludeDir should be passed to the driver`) {
+			return
+		}
+		if !assert.True(t, exclude("/src/vendor"), `vendor should be excluded`) {
+			return
+		}
+		if !assert.False(t, exclude("/src/pkg"), `pkg should not be excluded`) {
+			return
+		}
+	})
+	t.Run("Events are filtered", func(t *testing.T) {
+		go func() {
+			driver.SendEvent(ctx, api.NewEvent("/src/README.md", api.OpMask(api.OpWrite)))
+			driver.SendEvent(ctx, api.NewEvent("/src/vendor/lib/lib.go", api.OpMask(api.OpWrite)))
+			driver.SendEvent(ctx, api.NewRenameEvent("/src/main.go", "/src/main.go.bak", api.OpMask(api.OpRename)))
+			driver.SendEvent(ctx, api.NewEvent("/src/main.go", api.OpMask(api.OpCreate)))
+		}()
+
+		for _, name := range []string{"/src/main.go.bak", "/src/main.go"} {
+			select {
+			case ev := <-eventCh:
+				if !assert.Equal(t, name, ev.Name(), `event should be for %q`, name) {
+					return
+				}
+			case <-ctx.Done():
+				assert.Fail(t, `timed out waiting for event`)
+				return
+			}
+		}
+	})
+}
+
+func Example() {
 	watcher := fsnotify.New()
 
 	ctx, cancel := context.WithCancel(context.Background())
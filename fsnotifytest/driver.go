@@ -0,0 +1,327 @@
+// Package fsnotifytest provides utilities for testing code that is
+// built on top of fsnotify.
+//
+// The Driver in this package implements api.Driver without touching
+// the file system: events and errors are injected by the test, and the
+// calls to Add() and Remove() are recorded so that they can be inspected.
+package fsnotifytest
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"github.com/lestrrat-go/fsnotify/api"
+)
+
+type CallType int
+
+const (
+	CallAdd CallType = iota + 1
+	CallRemove
+)
+
+func (typ CallType) String() string {
+	switch typ {
+	case CallAdd:
+		return "Add"
+	case CallRemove:
+		return "Remove"
+	default:
+		return "INVALID CALL"
+	}
+}
+
+// Call is a record of a call to Driver.Add() or Driver.Remove()
+type Call struct {
+	Type    CallType
+	Path    string
+	Options []api.CommandOption
+}
+
+// delivery is an event or an error that is waiting to be sent
+// by the Run() goroutine
+type delivery struct {
+	event api.Event
+	err   error
+	fatal bool
+	done  chan struct{}
+}
+
+// Driver is a fake api.Driver that is fully controlled by the test.
+type Driver struct {
+	mu          sync.Mutex
+	calls       []Call
+	targets     map[string]struct{}
+	addErrors   map[string]error
+	asyncErrors []error
+	running     bool
+	changed     chan struct{} // closed and replaced every time the state changes
+
+	manualReady bool
+	readyOnce   sync.Once
+	readyCh     chan struct{}
+
+	deliveries chan *delivery
+	notify     chan struct{}
+}
+
+func NewDriver(options ...DriverOption) *Driver {
+	d := &Driver{
+		targets:    make(map[string]struct{}),
+		addErrors:  make(map[string]error),
+		changed:    make(chan struct{}),
+		readyCh:    make(chan struct{}),
+		deliveries: make(chan *delivery),
+		notify:     make(chan struct{}, 1),
+	}
+	for _, option := range options {
+		//nolint:forcetypeassert
+		switch option.Ident() {
+		case identManualReady{}:
+			d.manualReady = option.Value().(bool)
+		}
+	}
+	return d
+}
+
+// must be called while holding d.mu
+func (d *Driver) signal() {
+	close(d.changed)
+	d.changed = make(chan struct{})
+}
+
+// Add records the call, and registers the path as a target. If an
+// error was registered for the path using FailAdd(), the error is
+// returned when api.WithAck(true) is specified, and sent to the error
+// sink otherwise.
+func (d *Driver) Add(path string, options ...api.CommandOption) error {
+	d.mu.Lock()
+	d.calls = append(d.calls, Call{Type: CallAdd, Path: path, Options: options})
+	err, failed := d.addErrors[path]
+	if !failed {
+		d.targets[path] = struct{}{}
+	}
+	d.signal()
+	d.mu.Unlock()
+
+	if !failed {
+		return nil
+	}
+	return d.reportCmdError(err, options)
+}
+
+// Remove records the call, and unregisters the path. Removing a path
+// that is not a target is an error.
+func (d *Driver) Remove(path string, options ...api.CommandOption) error {
+	d.mu.Lock()
+	d.calls = append(d.calls, Call{Type: CallRemove, Path: path, Options: options})
+	_, ok := d.targets[path]
+	delete(d.targets, path)
+	d.signal()
+	d.mu.Unlock()
+
+	if ok {
+		return nil
+	}
+	return d.reportCmdError(api.NewError("fsnotifytest", "remove", path, api.ErrNotWatched), options)
+}
+
+func (d *Driver) reportCmdError(err error, options []api.CommandOption) error {
+	for _, option := range options {
+		//nolint:forcetypeassert
+		if api.IsAck(option.Ident()) && option.Value().(bool) {
+			return err
+		}
+	}
+
+	d.mu.Lock()
+	d.asyncErrors = append(d.asyncErrors, err)
+	d.mu.Unlock()
+
+	select {
+	case d.notify <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// FailAdd makes subsequent calls to Add() for path fail with err.
+// Passing a nil error clears the failure.
+func (d *Driver) FailAdd(path string, err error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if err == nil {
+		delete(d.addErrors, path)
+		return
+	}
+	d.addErrors[path] = err
+}
+
+// Ready marks the driver as ready, when it was created with
+// WithManualReady(true). It is safe to call it multiple times.
+func (d *Driver) Ready() {
+	d.readyOnce.Do(func() { close(d.readyCh) })
+}
+
+// Run delivers the events and errors that are sent by the test. It
+// returns nil when ctx is done, or the error passed to Fail().
+func (d *Driver) Run(ctx context.Context, ready chan struct{}, evsink api.EventSink, errsink api.ErrorSink) error {
+	d.setRunning(true)
+	defer d.setRunning(false)
+
+	if d.manualReady {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-d.readyCh:
+		}
+	}
+	close(ready)
+
+	for {
+		d.mu.Lock()
+		errs := d.asyncErrors
+		d.asyncErrors = nil
+		d.mu.Unlock()
+		for _, err := range errs {
+			errsink.Error(err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-d.notify:
+		case dlv := <-d.deliveries:
+			if dlv.fatal {
+				close(dlv.done)
+				return dlv.err
+			}
+			if dlv.event != nil {
+				evsink.Event(dlv.event)
+			}
+			if dlv.err != nil {
+				errsink.Error(dlv.err)
+			}
+			close(dlv.done)
+		}
+	}
+}
+
+func (d *Driver) setRunning(b bool) {
+	d.mu.Lock()
+	d.running = b
+	d.signal()
+	d.mu.Unlock()
+}
+
+func (d *Driver) deliver(ctx context.Context, dlv *delivery) error {
+	dlv.done = make(chan struct{})
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case d.deliveries <- dlv:
+	}
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-dlv.done:
+		return nil
+	}
+}
+
+// SendEvent sends ev to the event sink of the running driver. It blocks
+// until the driver is running and the event sink has accepted the event,
+// or until ctx is done.
+func (d *Driver) SendEvent(ctx context.Context, ev api.Event) error {
+	return d.deliver(ctx, &delivery{event: ev})
+}
+
+// SendError sends err to the error sink of the running driver. It blocks
+// until the driver is running and the error sink has accepted the error,
+// or until ctx is done.
+func (d *Driver) SendError(ctx context.Context, err error) error {
+	return d.deliver(ctx, &delivery{err: err})
+}
+
+// Fail simulates a fatal driver failure: Run() returns err. It blocks
+// until the driver is running and has stopped, or until ctx is done.
+func (d *Driver) Fail(ctx context.Context, err error) error {
+	return d.deliver(ctx, &delivery{err: err, fatal: true})
+}
+
+// Running returns true if Run() is currently executing
+func (d *Driver) Running() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.running
+}
+
+// Calls returns the list of calls to Add() and Remove() that were
+// made so far, in the order that they were made.
+func (d *Driver) Calls() []Call {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	calls := make([]Call, len(d.calls))
+	copy(calls, d.calls)
+	return calls
+}
+
+// Targets returns the sorted list of paths that are currently added
+func (d *Driver) Targets() []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	targets := make([]string, 0, len(d.targets))
+	for path := range d.targets {
+		targets = append(targets, path)
+	}
+	sort.Strings(targets)
+	return targets
+}
+
+// WaitFor blocks until cond returns true, or until ctx is done. cond is
+// evaluated every time the state of the driver changes (calls to Add()
+// and Remove(), and Run() starting or stopping).
+func (d *Driver) WaitFor(ctx context.Context, cond func(*Driver) bool) error {
+	for {
+		d.mu.Lock()
+		changed := d.changed
+		d.mu.Unlock()
+
+		if cond(d) {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-changed:
+		}
+	}
+}
+
+// WaitForTarget blocks until path is added, or until ctx is done
+func (d *Driver) WaitForTarget(ctx context.Context, path string) error {
+	return d.WaitFor(ctx, func(d *Driver) bool {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		_, ok := d.targets[path]
+		return ok
+	})
+}
+
+// WaitForRemoval blocks until path is no longer a target, or until ctx is done
+func (d *Driver) WaitForRemoval(ctx context.Context, path string) error {
+	return d.WaitFor(ctx, func(d *Driver) bool {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		_, ok := d.targets[path]
+		return !ok
+	})
+}
+
+// WaitRunning blocks until Run() is executing, or until ctx is done
+func (d *Driver) WaitRunning(ctx context.Context) error {
+	return d.WaitFor(ctx, (*Driver).Running)
+}
@@ -0,0 +1,118 @@
+package fsnotifytest_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/lestrrat-go/fsnotify/api"
+	"github.com/lestrrat-go/fsnotify/fsnotifytest"
+	"github.com/stretchr/testify/assert"
+)
+
+// Sanity
+var _ api.Driver = &fsnotifytest.Driver{}
+
+type chanEventSink chan api.Event
+
+func (sink chanEventSink) Event(ev api.Event) {
+	sink <- ev
+}
+
+func TestDriver(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	evCh := make(chan api.Event, 1)
+	errCh := make(chan error, 1)
+	ready := make(chan struct{})
+	done := make(chan error, 1)
+
+	driver := fsnotifytest.NewDriver(fsnotifytest.WithManualReady(true))
+	go func() {
+		done <- driver.Run(ctx, ready, chanEventSink(evCh), api.ChanErrSink(errCh))
+	}()
+
+	t.Run("Manual ready", func(t *testing.T) {
+		if !assert.NoError(t, driver.WaitRunning(ctx), `driver.WaitRunning should succeed`) {
+			return
+		}
+		select {
+		case <-ready:
+			assert.Fail(t, `driver should not be ready until driver.Ready() is called`)
+			return
+		default:
+		}
+
+		driver.Ready()
+		select {
+		case <-ready:
+		case <-ctx.Done():
+			assert.Fail(t, `driver should be ready after driver.Ready() is called`)
+		}
+	})
+	t.Run("Add and Remove", func(t *testing.T) {
+		addErr := errors.New(`boom`)
+		driver.FailAdd("/bar", addErr)
+
+		if !assert.NoError(t, driver.Add("/foo", api.WithAck(true)), `driver.Add should succeed`) {
+			return
+		}
+		if !assert.Equal(t, addErr, driver.Add("/bar", api.WithAck(true)), `driver.Add should fail with the registered error`) {
+			return
+		}
+		if !assert.NoError(t, driver.Add("/bar"), `driver.Add without ack should succeed`) {
+			return
+		}
+		select {
+		case err := <-errCh:
+			if !assert.Equal(t, addErr, err, `error should be sent to the error sink`) {
+				return
+			}
+		case <-ctx.Done():
+			assert.Fail(t, `timed out waiting for error`)
+			return
+		}
+
+		if !assert.Equal(t, []string{"/foo"}, driver.Targets(), `targets should match`) {
+			return
+		}
+		if !assert.NoError(t, driver.Remove("/foo", api.WithAck(true)), `driver.Remove should succeed`) {
+			return
+		}
+		if !assert.Error(t, driver.Remove("/foo", api.WithAck(true)), `driver.Remove should fail for a path that is not a target`) {
+			return
+		}
+
+		calls := driver.Calls()
+		if !assert.Len(t, calls, 5, `there should be 5 calls`) {
+			return
+		}
+		if !assert.Equal(t, fsnotifytest.CallRemove, calls[3].Type, `calls[3] should be a Remove`) {
+			return
+		}
+	})
+	t.Run("Events and errors", func(t *testing.T) {
+		ev := api.NewEvent("/foo", api.OpMask(api.OpWrite))
+		if !assert.NoError(t, driver.SendEvent(ctx, ev), `driver.SendEvent should succeed`) {
+			return
+		}
+		if !assert.Equal(t, ev, <-evCh, `event should be sent to the event sink`) {
+			return
+		}
+
+		err := errors.New(`failure`)
+		if !assert.NoError(t, driver.Fail(ctx, err), `driver.Fail should succeed`) {
+			return
+		}
+		select {
+		case runErr := <-done:
+			if !assert.Equal(t, err, runErr, `Run should return the error passed to driver.Fail`) {
+				return
+			}
+		case <-ctx.Done():
+			assert.Fail(t, `Run should return after driver.Fail`)
+		}
+	})
+}
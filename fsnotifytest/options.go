@@ -0,0 +1,24 @@
+package fsnotifytest
+
+import "github.com/lestrrat-go/option"
+
+type Option = option.Interface
+type DriverOption interface {
+	Option
+	driverOption()
+}
+
+type driverOption struct {
+	Option
+}
+
+func (*driverOption) driverOption() {}
+
+type identManualReady struct{}
+
+// WithManualReady specifies that Run() should not report the driver
+// as ready until Driver.Ready() is called. This can be used to test
+// code that depends on the driver taking time to initialize.
+func WithManualReady(b bool) DriverOption {
+	return &driverOption{option.New(identManualReady{}, b)}
+}
@@ -0,0 +1,21 @@
+//go:build linux
+// +build linux
+
+package inotify
+
+import "golang.org/x/sys/unix"
+
+// FailAddWatch makes inotify_add_watch(2) fail with ENOSPC for the
+// paths for which fail returns true, as if the limit on the number of
+// watches was reached. The returned function restores the original
+// behavior.
+func FailAddWatch(fail func(string) bool) func() {
+	orig := inotifyAddWatch
+	inotifyAddWatch = func(fd int, path string, mask uint32) (int, error) {
+		if fail(path) {
+			return -1, unix.ENOSPC
+		}
+		return orig(fd, path, mask)
+	}
+	return func() { inotifyAddWatch = orig }
+}
@@ -0,0 +1,191 @@
+//go:build linux
+// +build linux
+
+package inotify
+
+import (
+	"path/filepath"
+	"sort"
+
+	"github.com/lestrrat-go/fsnotify/api"
+	"golang.org/x/sys/unix"
+)
+
+// followTarget is a target that was added with api.WithFollowName. The
+// directory that contains it is watched as an anchor, so that we notice
+// when another file takes its place.
+type followTarget struct {
+	req      *addRequest
+	dir      string // directory that contains the target, watched as an anchor
+	dev, ino uint64 // file that is currently being watched, zero if there is none
+}
+
+// addFollow adds a target that follows its name. Errors that do not
+// prevent the target from being watched are returned separately, so
+// that they can be reported once rctx.mu is released. rctx.mu must be
+// held by the caller.
+func (rctx *runCtx) addFollow(req *addRequest) ([]error, error) {
+	ft, ok := rctx.follows[req.path]
+	if ok {
+		merged := *ft.req
+		merged.ops |= req.ops
+		merged.recursive = merged.recursive || req.recursive
+		if req.exclude != nil {
+			merged.exclude = req.exclude
+		}
+		ft.req = &merged
+	} else {
+		ft = &followTarget{req: req}
+	}
+
+	// The file is identified before it is watched. If it is replaced in
+	// between, the next look at it finds that it has changed.
+	var st unix.Stat_t
+	if err := unix.Stat(req.path, &st); err != nil {
+		return nil, err
+	}
+	_, errs, err := rctx.watchTarget(ft.req)
+	if err != nil {
+		return errs, err
+	}
+
+	if !ok {
+		dir := filepath.Dir(req.path)
+		if err := rctx.addAnchor(dir); err != nil {
+			if rmErr := rctx.removeWatch(req.path); rmErr != nil {
+				errs = append(errs, rmErr)
+			}
+			return errs, err
+		}
+		ft.dir = dir
+	}
+	ft.dev, ft.ino = uint64(st.Dev), uint64(st.Ino)
+	rctx.follows[req.path] = ft
+	return errs, nil
+}
+
+// removeFollow stops following the name of a target, and returns true
+// if the target itself is still being watched. rctx.mu must be held by
+// the caller.
+func (rctx *runCtx) removeFollow(ft *followTarget) (bool, error) {
+	delete(rctx.follows, ft.req.path)
+	return ft.ino != 0, rctx.removeAnchor(ft.dir)
+}
+
+// rebind makes sure that the file that is found at the path of a target
+// is the one being watched. It returns the operation that should be
+// reported for the target, if any. rctx.mu must be held by the caller.
+func (rctx *runCtx) rebind(ft *followTarget) (api.Op, []error) {
+	path := ft.req.path
+
+	var st unix.Stat_t
+	if err := unix.Stat(path, &st); err != nil {
+		// The target is gone. Its removal is reported by its own watch,
+		// unless it was moved away, in which case the watch went with it
+		var errs []error
+		if ft.ino != 0 {
+			errs = rctx.unbind(ft)
+		}
+		return 0, errs
+	}
+	if ft.ino == uint64(st.Ino) && ft.dev == uint64(st.Dev) {
+		return 0, nil
+	}
+
+	var op api.Op = api.OpCreate
+	var errs []error
+	if ft.ino != 0 {
+		op = api.OpWrite
+		errs = rctx.unbind(ft)
+	}
+	_, watchErrs, err := rctx.watchTarget(ft.req)
+	errs = append(errs, watchErrs...)
+	if err != nil {
+		// Most likely the file was replaced again, in which case the
+		// next event in its directory is going to bring us back here
+		errs = append(errs, newError("add", path, err))
+		return 0, errs
+	}
+	ft.dev, ft.ino = uint64(st.Dev), uint64(st.Ino)
+	return op, errs
+}
+
+// unbind stops watching the file that the target used to refer to.
+// rctx.mu must be held by the caller.
+func (rctx *runCtx) unbind(ft *followTarget) []error {
+	var errs []error
+	if ft.req.recursive {
+		errs = rctx.removeTree(ft.req.path)
+	}
+	if err := rctx.removeWatch(ft.req.path); err != nil {
+		errs = append(errs, err)
+	}
+	ft.dev, ft.ino = 0, 0
+	return errs
+}
+
+// updateFollows is called for every event that was received by the
+// watch for dir, where name is the path that the event is about. When
+// a file is created in place of a target, or the file that a target
+// refers to goes away, the target is bound to whatever is now found
+// at its path.
+func (rctx *runCtx) updateFollows(dir, name string, rawMask uint32) {
+	rctx.mu.Lock()
+	var targets []*followTarget
+	for _, ft := range rctx.follows {
+		switch {
+		case ft.dir == dir && name == ft.req.path && rawMask&(unix.IN_CREATE|unix.IN_MOVED_TO) != 0:
+			targets = append(targets, ft)
+		case ft.req.path == dir && rawMask&(unix.IN_DELETE_SELF|unix.IN_MOVE_SELF) != 0:
+			targets = append(targets, ft)
+		}
+	}
+	events, errs := rctx.rebindAll(targets)
+	rctx.mu.Unlock()
+
+	for _, ev := range events {
+		rctx.evsink.Event(ev)
+	}
+	for _, err := range errs {
+		rctx.errsink.Error(err)
+	}
+}
+
+// retryFollows binds all targets that follow their names to whatever is
+// found at their paths, e.g. after events were lost
+func (rctx *runCtx) retryFollows() {
+	rctx.mu.Lock()
+	targets := make([]*followTarget, 0, len(rctx.follows))
+	for _, ft := range rctx.follows {
+		targets = append(targets, ft)
+	}
+	events, errs := rctx.rebindAll(targets)
+	rctx.mu.Unlock()
+
+	for _, ev := range events {
+		rctx.evsink.Event(ev)
+	}
+	for _, err := range errs {
+		rctx.errsink.Error(err)
+	}
+}
+
+// rebindAll rebinds the given targets, and returns the events that
+// should be reported. rctx.mu must be held by the caller.
+func (rctx *runCtx) rebindAll(targets []*followTarget) ([]api.Event, []error) {
+	// Report the targets in a stable order
+	sort.Slice(targets, func(i, j int) bool {
+		return targets[i].req.path < targets[j].req.path
+	})
+
+	var events []api.Event
+	var errs []error
+	for _, ft := range targets {
+		op, rebindErrs := rctx.rebind(ft)
+		errs = append(errs, rebindErrs...)
+		if op != 0 && ft.req.ops.IsSet(op) {
+			events = append(events, api.NewEvent(ft.req.path, api.OpMask(op)))
+		}
+	}
+	return events, errs
+}
@@ -0,0 +1,188 @@
+//go:build linux
+// +build linux
+
+package inotify
+
+import (
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/lestrrat-go/fsnotify/api"
+	"golang.org/x/sys/unix"
+)
+
+// inodeFlags are the inotify flags that are required to notice that a
+// file that follows its inode was moved, or that its name went away
+const inodeFlags = unix.IN_MOVE_SELF | unix.IN_ATTRIB
+
+// inodeTarget is a file that was added with api.WithFollowInode. An
+// O_PATH file descriptor is kept open for it, so that its current name
+// can be looked up in /proc/self/fd after it was moved.
+type inodeTarget struct {
+	fd int
+}
+
+// addInode adds a file that follows its inode. Directories are watched
+// as usual: the file descriptor would keep the kernel from reporting
+// their removal. Errors that do not prevent the target from being
+// watched are returned separately, so that they can be reported once
+// rctx.mu is released. rctx.mu must be held by the caller.
+func (rctx *runCtx) addInode(req *addRequest) ([]error, error) {
+	// The file is opened before it is watched, so that we never end up
+	// following a file other than the one that is being watched
+	fd, err := unix.Open(req.path, unix.O_PATH|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, err
+	}
+	_, errs, err := rctx.watchTarget(req)
+	if err != nil {
+		unix.Close(fd)
+		return errs, err
+	}
+
+	var st unix.Stat_t
+	if err := unix.Fstat(fd, &st); err != nil || st.Mode&unix.S_IFMT == unix.S_IFDIR {
+		unix.Close(fd)
+		return errs, err
+	}
+	if _, ok := rctx.inodes[req.path]; ok {
+		unix.Close(fd)
+		return errs, nil
+	}
+
+	if wd, errno := inotifyAddWatch(rctx.infd, req.path, inodeFlags|unix.IN_MASK_ADD); wd == -1 {
+		unix.Close(fd)
+		if errno == unix.ENOSPC {
+			return errs, newLimitError("max_user_watches", len(rctx.paths), errno)
+		}
+		return errs, errno
+	}
+	rctx.watches[req.path].flags |= inodeFlags
+	rctx.inodes[req.path] = &inodeTarget{fd: fd}
+	return errs, nil
+}
+
+// forgetInode stops following the inode of a target. rctx.mu must be
+// held by the caller.
+func (rctx *runCtx) forgetInode(path string) {
+	if it, ok := rctx.inodes[path]; ok {
+		unix.Close(it.fd)
+		delete(rctx.inodes, path)
+	}
+}
+
+// currentPath returns the current path of the file that fd refers to,
+// or an empty string if the file no longer has a name
+func currentPath(fd int) (string, error) {
+	var st unix.Stat_t
+	if err := unix.Fstat(fd, &st); err != nil {
+		return "", err
+	}
+	if st.Nlink == 0 {
+		return "", nil
+	}
+
+	path, err := os.Readlink("/proc/self/fd/" + strconv.Itoa(fd))
+	if err != nil {
+		return "", err
+	}
+	// The kernel marks names that were unlinked, while the file lives
+	// on through other hard links
+	if strings.HasSuffix(path, " (deleted)") {
+		if _, err := os.Lstat(path); os.IsNotExist(err) {
+			return "", nil
+		}
+	}
+	return path, nil
+}
+
+// followInode is called for IN_MOVE_SELF and IN_ATTRIB events that were
+// received by the watch for path. If path follows its inode, the watch is
+// moved to the current name of the file, and a rename event is reported
+// whether or not it was requested, so that the caller learns the new
+// name of the target. If the file no longer has a name, the watch is
+// removed, and a REMOVE event is reported. It returns true if the event
+// was handled.
+func (rctx *runCtx) followInode(path string, rawMask uint32) bool {
+	rctx.mu.Lock()
+	it, ok := rctx.inodes[path]
+	if !ok {
+		rctx.mu.Unlock()
+		return false
+	}
+	ops := rctx.watches[path].ops
+
+	var handled bool
+	var events []api.Event
+	var errs []error
+	newPath, err := currentPath(it.fd)
+	switch {
+	case err != nil:
+		errs = append(errs, newError("follow", path, err))
+	case newPath == "":
+		// Removing the watch before the file descriptor is closed keeps
+		// the kernel from reporting IN_DELETE_SELF as well
+		if err := rctx.removeWatch(path); err != nil {
+			errs = append(errs, err)
+		}
+		rctx.forgetInode(path)
+		if ops.IsSet(api.OpRemove) {
+			events = append(events, api.NewEvent(path, api.OpMask(api.OpRemove)))
+		}
+		handled = true
+	case rawMask&unix.IN_MOVE_SELF != 0:
+		if newPath != path {
+			errs = append(errs, rctx.moveWatch(path, newPath)...)
+			events = append(events, api.NewRenameEvent(path, newPath, api.OpMask(api.OpRename)))
+		}
+		handled = true
+	}
+	rctx.mu.Unlock()
+
+	for _, ev := range events {
+		rctx.evsink.Event(ev)
+	}
+	for _, err := range errs {
+		rctx.errsink.Error(err)
+	}
+	return handled
+}
+
+// moveWatch updates our state after the file that follows its inode
+// was moved from oldPath to newPath. rctx.mu must be held by the caller.
+func (rctx *runCtx) moveWatch(oldPath, newPath string) []error {
+	var errs []error
+	// The file may have replaced another file that was being watched.
+	// That watch belongs to a file that is gone.
+	if watchEntry, ok := rctx.watches[newPath]; ok && watchEntry != rctx.watches[oldPath] {
+		if err := rctx.removeWatch(newPath); err != nil {
+			errs = append(errs, err)
+		}
+		rctx.forgetInode(newPath)
+	}
+
+	watchEntry := rctx.watches[oldPath]
+	delete(rctx.watches, oldPath)
+	rctx.watches[newPath] = watchEntry
+	rctx.paths[int(watchEntry.wd)] = newPath
+	rctx.inodes[newPath] = rctx.inodes[oldPath]
+	delete(rctx.inodes, oldPath)
+
+	if rctx.snapshot != nil {
+		var scanErrs []error
+		rctx.snapshot, scanErrs = rctx.scan()
+		errs = append(errs, scanErrs...)
+	}
+	return errs
+}
+
+// closeInodes closes the file descriptors of all targets that follow
+// their inodes
+func (rctx *runCtx) closeInodes() {
+	rctx.mu.Lock()
+	defer rctx.mu.Unlock()
+	for path := range rctx.inodes {
+		rctx.forgetInode(path)
+	}
+}
@@ -5,14 +5,20 @@ package inotify
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"os"
+	"path/filepath"
 	"strings"
 	"sync"
+	"time"
 	"unsafe"
 
 	"github.com/lestrrat-go/fsnotify/api"
+	"github.com/lestrrat-go/fsnotify/poll"
+	"github.com/lestrrat-go/fsnotify/snapshot"
 	"golang.org/x/sys/unix"
 )
 
@@ -25,17 +31,74 @@ const (
 
 var ErrEventOverflow = fmt.Errorf(`fsnotify queue overflow`)
 
+// inotifyAddWatch is replaced in tests to simulate reaching the limit
+// on the number of watches
+var inotifyAddWatch = unix.InotifyAddWatch
+
+// DriverName is the name of the driver that is reported in api.Error
+const DriverName = "inotify"
+
+// newError creates a new api.Error for this driver. Running out of
+// watches or inotify instances is reported as api.ErrWatchLimit.
+func newError(op, path string, err error) *api.Error {
+	e := api.NewError(DriverName, op, path, err)
+	if errors.Is(err, unix.ENOSPC) || errors.Is(err, unix.EMFILE) {
+		e.Kind = api.ErrWatchLimit
+	}
+	return e
+}
+
+// newScanErrors converts the errors from a snapshot into api.Error values
+func newScanErrors(errs []error) []error {
+	for i, err := range errs {
+		var path string
+		var pathErr *fs.PathError
+		if errors.As(err, &pathErr) {
+			path = pathErr.Path
+		}
+		errs[i] = newError("scan", path, err)
+	}
+	return errs
+}
+
+// newFatalError creates a new api.Error for failures that the driver
+// can not recover from
+func newFatalError(op string, err error) *api.Error {
+	e := newError(op, "", err)
+	e.Fatal = true
+	return e
+}
+
 // Driver is the inotify backed fsnotify driver.
 // The driver itself doesn't keep state. Stateful operations
 // are abstracted within the Run() method.
 type Driver struct {
-	control chan *api.Command
-	data    chan interface{}
-	pending *api.CommandQueue
+	control          chan *api.Command
+	data             chan interface{}
+	pending          *api.CommandQueue
+	overflowRecovery bool
+	pollInterval     time.Duration // interval for the poll fallback, 0 if disabled
+
+	mu   sync.Mutex
+	rctx *runCtx // the state of the running driver, if any
 }
 
-func New() *Driver {
-	d := &Driver{}
+// New creates a new inotify driver.
+func New(options ...DriverOption) *Driver {
+	d := &Driver{
+		control: make(chan *api.Command),
+	}
+	for _, option := range options {
+		//nolint:gocritic
+		switch option.Ident() {
+		case identOverflowRecovery{}:
+			//nolint:forcetypeassert
+			d.overflowRecovery = option.Value().(bool)
+		case identPollFallback{}:
+			//nolint:forcetypeassert
+			d.pollInterval = option.Value().(time.Duration)
+		}
+	}
 	d.pending = api.NewCommandQueue(api.CommandQueueEgressChooseFunc(func(cmd *api.Command) chan *api.Command {
 		switch cmd.Type {
 		case cmdAdd, cmdRemove:
@@ -50,18 +113,51 @@ func New() *Driver {
 type runCtx struct {
 	mu sync.RWMutex
 
-	epfd     int // epoll fd
-	infd     int // inotify fd
-	wakeupfd int // read fd for pipe used to wake up epoll
-	evsink   api.EventSink
-	errsink  api.ErrorSink
-	paths    map[int]string
-	watches  map[string]*watch
+	ctx context.Context // done when Run() returns
+
+	epfd      int // epoll fd
+	infd      int // inotify fd
+	wakeupfd  int // read fd for pipe used to wake up epoll
+	wakeupwfd int // write fd for pipe used to wake up epoll
+	evsink    api.EventSink
+	errsink   api.ErrorSink
+	paths     map[int]string
+	watches   map[string]*watch
+	moves     []*move                   // IN_MOVED_FROM events waiting for their IN_MOVED_TO counterpart
+	snapshot  snapshot.Snapshot         // state of the watched paths, only kept if overflow recovery is enabled
+	poller    *poll.Driver              // polls the paths that could not be watched, if the poll fallback is enabled
+	polled    map[string]bool           // paths that are polled, mapped to true if they were explicitly requested
+	pending   map[string]*pendingTarget // targets that were added with api.WithPending, by path
+	follows   map[string]*followTarget  // targets that were added with api.WithFollowName, by path
+	inodes    map[string]*inodeTarget   // files that were added with api.WithFollowInode, by current path
 }
 
 type watch struct {
-	wd    uint32 // Watch descriptor (as returned by the inotify_add_watch() syscall)
-	flags uint32 // inotify flags of this watch (see inotify(7) for the list of valid flags)
+	wd        uint32            // Watch descriptor (as returned by the inotify_add_watch() syscall)
+	flags     uint32            // inotify flags of this watch (see inotify(7) for the list of valid flags)
+	ops       api.OpMask        // operations that should be reported for this watch
+	recursive bool              // true if directories below this one should be watched as well
+	root      bool              // true if this watch was explicitly requested, as opposed to being found by recursion
+	exclude   func(string) bool // directories for which this returns true are not watched recursively
+	anchors   int               // number of pending targets that are waiting for entries to be created in this directory
+}
+
+func (watchEntry *watch) scanOptions() []snapshot.ScanOption {
+	return []snapshot.ScanOption{
+		snapshot.WithRecursive(watchEntry.recursive),
+		snapshot.WithExcludeDir(watchEntry.exclude),
+	}
+}
+
+// addRequest is the payload for cmdAdd
+type addRequest struct {
+	path        string
+	ops         api.OpMask
+	recursive   bool
+	exclude     func(string) bool
+	pending     bool
+	follow      bool
+	followInode bool
 }
 
 func epollAdd(fd, epfd int) error {
@@ -77,7 +173,7 @@ func epollAdd(fd, epfd int) error {
 
 func (rctx *runCtx) epollWake() error {
 	var buf [1]byte
-	n, err := unix.Write(rctx.wakeupfd, buf[:])
+	n, err := unix.Write(rctx.wakeupwfd, buf[:])
 	if n != -1 {
 		return nil
 	}
@@ -101,76 +197,153 @@ func (rctx *runCtx) epollWakeClear() error {
 	return err
 }
 
-func (driver *Driver) Run(ctx context.Context, ready chan struct{}, evsink api.EventSink, errsink api.ErrorSink) {
-	driver.control = make(chan *api.Command)
-
+// Run starts the driver. It returns a fatal *api.Error if the inotify
+// instance can not be set up (e.g. because the limit on the number of
+// inotify instances was reached), or if waiting for events fails.
+func (driver *Driver) Run(ctx context.Context, ready chan struct{}, evsink api.EventSink, errsink api.ErrorSink) error {
 	infd, err := unix.InotifyInit1(unix.IN_CLOEXEC)
 	if infd == -1 {
-		errsink.Error(fmt.Errorf(`failed to create inotify fd: %w`, err))
-		return
+		if err == unix.EMFILE {
+			err = newLimitError("max_user_instances", 0, err)
+		}
+		return newFatalError("init", fmt.Errorf(`failed to create inotify fd: %w`, err))
 	}
 	defer unix.Close(infd)
 
 	// Create epoll
 	epfd, err := unix.EpollCreate1(unix.EPOLL_CLOEXEC)
 	if epfd == -1 {
-		errsink.Error(err)
-		return
+		return newFatalError("init", fmt.Errorf(`failed to create epoll fd: %w`, err))
 	}
+	defer unix.Close(epfd)
 
 	if err := epollAdd(infd, epfd); err != nil {
-		errsink.Error(fmt.Errorf(`failed to register inotify fd to epoll: %w`, err))
-		return
+		return newFatalError("init", fmt.Errorf(`failed to register inotify fd to epoll: %w`, err))
 	}
 
 	// Create pipe to interrupt/wake up the epoll_wait when
 	// we detect <-ctx.Done(). This is only valid during Run() time
 	var pipe [2]int
 	if errno := unix.Pipe2(pipe[:], unix.O_NONBLOCK|unix.O_CLOEXEC); errno != nil {
-		errsink.Error(fmt.Errorf(`failed to create pipe: %w`, errno))
-		return
+		return newFatalError("init", fmt.Errorf(`failed to create pipe: %w`, errno))
 	}
+	defer unix.Close(pipe[0])
+	defer unix.Close(pipe[1])
 
 	if err := epollAdd(pipe[0], epfd); err != nil {
-		errsink.Error(fmt.Errorf(`failed to register pipe to epoll: %w`, err))
-		return
+		return newFatalError("init", fmt.Errorf(`failed to register pipe to epoll: %w`, err))
 	}
 
 	rctx := runCtx{
-		epfd:     epfd,
-		infd:     infd,
-		wakeupfd: pipe[1],
-		evsink:   evsink,
-		errsink:  errsink,
-		paths:    make(map[int]string),
-		watches:  make(map[string]*watch),
+		ctx:       ctx,
+		epfd:      epfd,
+		infd:      infd,
+		wakeupfd:  pipe[0],
+		wakeupwfd: pipe[1],
+		evsink:    evsink,
+		errsink:   errsink,
+		paths:     make(map[int]string),
+		watches:   make(map[string]*watch),
+		pending:   make(map[string]*pendingTarget),
+		follows:   make(map[string]*followTarget),
+		inodes:    make(map[string]*inodeTarget),
+	}
+	defer rctx.closeInodes()
+	if driver.overflowRecovery {
+		rctx.snapshot = make(snapshot.Snapshot)
 	}
 
-	go driver.pending.Drain(ctx)
-	go rctx.doEpoll(ctx)
+	if driver.pollInterval > 0 {
+		rctx.poller = poll.New(poll.WithInterval(driver.pollInterval))
+		rctx.polled = make(map[string]bool)
+
+		pollCtx, pollCancel := context.WithCancel(ctx)
+		pollReady := make(chan struct{})
+		pollDone := make(chan error, 1)
+		go func() {
+			pollDone <- rctx.poller.Run(pollCtx, pollReady, evsink, errsink)
+		}()
+		defer func() {
+			pollCancel()
+			<-pollDone
+		}()
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case err := <-pollDone:
+			return err
+		case <-pollReady:
+		}
+	}
+
+	driver.mu.Lock()
+	driver.rctx = &rctx
+	driver.mu.Unlock()
+	defer func() {
+		driver.mu.Lock()
+		driver.rctx = nil
+		driver.mu.Unlock()
+	}()
+
+	// The commands that the queue holds on to are picked up by the next
+	// Run(), so this one must be done with the queue before it returns
+	drainCtx, drainCancel := context.WithCancel(ctx)
+	drainDone := make(chan struct{})
+	go func() {
+		defer close(drainDone)
+		driver.pending.Drain(drainCtx)
+	}()
+	defer func() {
+		drainCancel()
+		<-drainDone
+	}()
+
+	// The file descriptors are closed when Run() returns, so we must
+	// make sure that the epoll goroutine is done with them before that
+	epollDone := make(chan error, 1)
+	go func() {
+		epollDone <- rctx.doEpoll(ctx)
+	}()
 
 	close(ready)
 	for {
 		select {
 		case <-ctx.Done():
-			rctx.epollWake()
-			return
+			if err := rctx.epollWake(); err != nil {
+				errsink.Error(newError("wakeup", "", err))
+			}
+			<-epollDone
+			return nil
+		case err := <-epollDone:
+			// nil if the epoll goroutine noticed that ctx is done first
+			return err
 		case cmd := <-driver.control:
+			var err error
 			switch cmd.Type {
 			case cmdAdd:
-				reply := cmd.Reply
-				if err := rctx.add(cmd.Payload.(string)); err != nil {
-					if reply != nil {
-						select {
-						case <-ctx.Done():
-						case reply <- err:
-						}
-					}
+				//nolint:forcetypeassert
+				req := cmd.Payload.(*addRequest)
+				err = rctx.add(req)
+			case cmdRemove:
+				//nolint:forcetypeassert
+				err = rctx.remove(cmd.Payload.(string))
+			}
+
+			reply := cmd.Reply
+			if reply == nil {
+				if err != nil {
+					errsink.Error(err)
 				}
-				if reply != nil {
-					close(reply)
+				continue
+			}
+			if err != nil {
+				select {
+				case <-ctx.Done():
+				case reply <- err:
 				}
 			}
+			close(reply)
 		}
 	}
 }
@@ -181,11 +354,66 @@ type cmdRequest struct {
 	reply chan error
 }
 
-// Add adds a new path to be watched by the driver
+// Add adds a new path to be watched by the driver.
+//
+// If api.WithRecursive(true) is specified and the path is a directory,
+// all directories below it are watched as well. Directories that are
+// created or moved into the tree afterwards are watched automatically,
+// and those that are removed or moved out of the tree are dropped.
+//
+// If api.WithOpMask() is specified, only the requested operations are
+// reported for the path, and the watch is set up with the minimum set of
+// inotify flags required to detect them.
+//
+// If api.WithExcludeDir() is specified, directories that are excluded
+// are not watched as part of a recursive watch.
+//
+// If api.WithPending(true) is specified, the path does not need to exist.
+// Until it does, the nearest existing directory above it is watched, and
+// a CREATE event is reported once the path is created. If the path is
+// removed or moved away later on, the driver goes back to waiting for it.
+//
+// If api.WithFollowName(true) is specified, the directory that contains
+// the path is watched as well. When the path is replaced by another file,
+// the new file is watched in place of the old one, and a single WRITE
+// event is reported.
+//
+// If api.WithFollowInode(true) is specified and the path is a file, the
+// watch follows the file when it is moved, even to a directory that is
+// not being watched. A rename event is reported, regardless of the
+// operations that were requested, and the watch is known under the new
+// name from then on, e.g. when it is passed to Remove().
 func (driver *Driver) Add(path string, options ...api.CommandOption) error {
+	req := &addRequest{path: path}
+	for _, option := range options {
+		switch ident := option.Ident(); {
+		case api.IsRecursive(ident):
+			//nolint:forcetypeassert
+			req.recursive = option.Value().(bool)
+		case api.IsOpMask(ident):
+			//nolint:forcetypeassert
+			req.ops |= option.Value().(api.OpMask)
+		case api.IsExcludeDir(ident):
+			//nolint:forcetypeassert
+			req.exclude = option.Value().(func(string) bool)
+		case api.IsPending(ident):
+			//nolint:forcetypeassert
+			req.pending = option.Value().(bool)
+		case api.IsFollowName(ident):
+			//nolint:forcetypeassert
+			req.follow = option.Value().(bool)
+		case api.IsFollowInode(ident):
+			//nolint:forcetypeassert
+			req.followInode = option.Value().(bool)
+		}
+	}
+	if req.ops == 0 {
+		req.ops = api.DefaultOpMask
+	}
+
 	cmd := &api.Command{
 		Type:    cmdAdd,
-		Payload: path,
+		Payload: req,
 	}
 	return driver.pending.SendCmd(cmd, options...)
 }
@@ -198,50 +426,357 @@ func (driver *Driver) Remove(path string, options ...api.CommandOption) error {
 	return driver.pending.SendCmd(cmd, options...)
 }
 
-func (rctx *runCtx) add(path string) error {
-	const agnosticEvents = unix.IN_MOVED_TO | unix.IN_MOVED_FROM |
-		unix.IN_CREATE | unix.IN_ATTRIB | unix.IN_MODIFY |
-		unix.IN_MOVE_SELF | unix.IN_DELETE | unix.IN_DELETE_SELF
-	var flags uint32 = agnosticEvents
+// Usage returns the inotify resources that are used by the driver,
+// along with the current limits. Nothing is in use while the driver
+// is not running.
+func (driver *Driver) Usage() (Usage, error) {
+	var usage Usage
+	driver.mu.Lock()
+	rctx := driver.rctx
+	driver.mu.Unlock()
+	if rctx != nil {
+		rctx.mu.RLock()
+		usage.Watches = len(rctx.paths)
+		usage.Polled = len(rctx.polled)
+		rctx.mu.RUnlock()
+	}
+
+	limits, err := ReadLimits()
+	usage.Limits = limits
+	return usage, err
+}
+
+func (rctx *runCtx) add(req *addRequest) error {
+	var addFunc func(*addRequest) ([]error, error)
+	switch {
+	case req.pending:
+		addFunc = rctx.addPending
+	case req.follow:
+		addFunc = rctx.addFollow
+	case req.followInode:
+		addFunc = rctx.addInode
+	}
 
 	rctx.mu.Lock()
-	defer rctx.mu.Unlock()
+	if addFunc != nil {
+		errs, err := addFunc(req)
+		rctx.mu.Unlock()
+		for _, err := range errs {
+			rctx.errsink.Error(err)
+		}
+		if err != nil {
+			return newError("add", req.path, err)
+		}
+		return nil
+	}
+
+	_, errs, err := rctx.watchTarget(req)
+	if err != nil {
+		if isLimitError(err) && rctx.poller != nil {
+			pollErr := rctx.fallback(req.path, req.ops, req.recursive, req.exclude, true)
+			rctx.mu.Unlock()
+			if pollErr != nil {
+				return pollErr
+			}
+			rctx.errsink.Error(newError("add", req.path, err))
+			return nil
+		}
+		rctx.mu.Unlock()
+		return newError("add", req.path, err)
+	}
+	rctx.mu.Unlock()
+
+	for _, err := range errs {
+		rctx.errsink.Error(err)
+	}
+	return nil
+}
+
+// watchTarget watches a path that was explicitly requested, and returns
+// the entries that were found below it if the watch is recursive. Errors
+// that do not prevent the path itself from being watched are returned
+// separately, so that they can be reported once rctx.mu is released.
+// rctx.mu must be held by the caller.
+func (rctx *runCtx) watchTarget(req *addRequest) ([]string, []error, error) {
+	if err := rctx.addWatch(req.path, req.ops, req.recursive, true); err != nil {
+		return nil, nil, err
+	}
+	watchEntry := rctx.watches[req.path]
+	if req.exclude != nil {
+		watchEntry.exclude = req.exclude
+	}
+
+	var found []string
+	var errs []error
+	if req.recursive {
+		found, errs = rctx.addTree(req.path, req.ops, watchEntry.exclude)
+	}
+	if rctx.snapshot != nil {
+		errs = append(errs, newScanErrors(rctx.snapshot.Add(req.path, watchEntry.scanOptions()...))...)
+	}
+	return found, errs, nil
+}
+
+// addWatch installs the inotify watch for a single path. If the path
+// is already being watched, the requested operations are merged with
+// the existing ones. rctx.mu must be held by the caller.
+func (rctx *runCtx) addWatch(path string, ops api.OpMask, recursive, root bool) error {
 	watchEntry := rctx.watches[path]
 	if watchEntry != nil {
-		flags |= watchEntry.flags | unix.IN_MASK_ADD
+		ops |= watchEntry.ops
+		recursive = recursive || watchEntry.recursive
 	}
-	wd, errno := unix.InotifyAddWatch(rctx.infd, path, flags)
+
+	// IN_DELETE_SELF is always required to keep our state in sync
+	// with the kernel, and recursive watches need to know about
+	// directories coming and going
+	flags := newInotifyFlags(ops) | unix.IN_DELETE_SELF
+	if recursive {
+		flags |= unix.IN_CREATE | unix.IN_MOVED_TO | unix.IN_MOVED_FROM
+	}
+
+	// IN_MASK_ADD makes sure that we never take away flags from an
+	// existing watch, which may be shared with another path (e.g. hard links)
+	wd, errno := inotifyAddWatch(rctx.infd, path, flags|unix.IN_MASK_ADD)
 	if wd == -1 {
+		if errno == unix.ENOSPC {
+			return newLimitError("max_user_watches", len(rctx.paths), errno)
+		}
 		return errno
 	}
 
 	if watchEntry == nil {
-		rctx.watches[path] = &watch{wd: uint32(wd), flags: flags}
-		rctx.paths[wd] = path
-	} else {
-		watchEntry.wd = uint32(wd)
-		watchEntry.flags = flags
+		watchEntry = &watch{}
+		rctx.watches[path] = watchEntry
+	}
+	watchEntry.wd = uint32(wd)
+	watchEntry.flags |= flags
+	watchEntry.ops = ops
+	watchEntry.recursive = recursive
+	watchEntry.root = watchEntry.root || root
+	rctx.paths[wd] = path
+
+	return nil
+}
+
+// addTree watches all directories below dir for the given operations,
+// and returns the list of entries that were found below dir. Directories
+// for which exclude returns true are skipped. Errors for individual
+// directories do not stop the walk, and are returned so that they can be
+// reported once rctx.mu is released. rctx.mu must be held by the caller.
+func (rctx *runCtx) addTree(dir string, ops api.OpMask, exclude func(string) bool) ([]string, []error) {
+	var found []string
+	var errs []error
+	//nolint:errcheck
+	filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			// The directory may have been removed while we were walking.
+			if !os.IsNotExist(err) {
+				errs = append(errs, newError("walk", path, err))
+			}
+			return nil
+		}
+
+		if path == dir {
+			return nil
+		}
+
+		if d.IsDir() {
+			if exclude != nil && exclude(path) {
+				// The directory itself lives in a watched directory,
+				// only its contents are left out
+				found = append(found, path)
+				return fs.SkipDir
+			}
+			if err := rctx.addWatch(path, ops, true, false); err != nil {
+				if !os.IsNotExist(err) {
+					errs = append(errs, newError("add", path, err))
+				}
+				if isLimitError(err) && rctx.poller != nil {
+					if err := rctx.fallback(path, ops, true, exclude, false); err != nil {
+						errs = append(errs, err)
+					} else {
+						found = append(found, path)
+					}
+				}
+				return fs.SkipDir
+			}
+			rctx.watches[path].exclude = exclude
+		}
+
+		found = append(found, path)
+		return nil
+	})
+	return found, errs
+}
+
+func (rctx *runCtx) remove(path string) error {
+	rctx.mu.Lock()
+	defer rctx.mu.Unlock()
+
+	if rctx.polled[path] {
+		delete(rctx.polled, path)
+		return rctx.poller.Remove(path, api.WithAck(true), api.WithContext(rctx.ctx))
+	}
+
+	rctx.forgetInode(path)
+	if pt, ok := rctx.pending[path]; ok {
+		delete(rctx.pending, path)
+		if pt.anchor != "" {
+			// The target itself is not being watched yet
+			return rctx.releaseAnchor(pt)
+		}
+	}
+	if ft, ok := rctx.follows[path]; ok {
+		watched, err := rctx.removeFollow(ft)
+		if !watched {
+			// The file that it referred to is gone
+			return err
+		}
+		if err != nil {
+			rctx.errsink.Error(err)
+		}
+	}
+
+	watchEntry, ok := rctx.watches[path]
+	if !ok || !watchEntry.root {
+		return newError("remove", path, api.ErrNotWatched)
+	}
+
+	// If the path is also covered by a recursive watch higher up
+	// the tree, the watch stays, but it is no longer a root
+	if rctx.coveredByParent(path) {
+		watchEntry.root = false
+		return nil
+	}
+
+	if watchEntry.recursive {
+		// Errors here are about watches that were never requested by
+		// the user, so there is not much to do other than to report them
+		for _, err := range rctx.removeTree(path) {
+			rctx.errsink.Error(err)
+		}
+	}
+	if watchEntry.anchors > 0 {
+		// Pending targets are still waiting for entries to be
+		// created in it, so the watch stays for their sake only
+		watchEntry.ops = 0
+		watchEntry.recursive = false
+		watchEntry.root = false
+		watchEntry.exclude = nil
+	} else if err := rctx.removeWatch(path); err != nil {
+		return err
 	}
 
+	// The snapshot may contain entries that are no longer watched
+	// through any other path, so it is easier to start from scratch
+	if rctx.snapshot != nil {
+		var errs []error
+		rctx.snapshot, errs = rctx.scan()
+		for _, err := range errs {
+			rctx.errsink.Error(err)
+		}
+	}
 	return nil
 }
 
-func (rctx *runCtx) doEpoll(ctx context.Context) {
+// coveredByParent returns true if the parent directory of path
+// is being watched recursively. rctx.mu must be held by the caller.
+func (rctx *runCtx) coveredByParent(path string) bool {
+	parent, ok := rctx.watches[filepath.Dir(path)]
+	return ok && parent.recursive
+}
+
+// removeTree removes the watches for all directories below dir
+// that were added as a result of a recursive watch. rctx.mu must
+// be held by the caller.
+func (rctx *runCtx) removeTree(dir string) []error {
+	var errs []error
+	prefix := dir + string(filepath.Separator)
+	for path, watchEntry := range rctx.watches {
+		if !strings.HasPrefix(path, prefix) || watchEntry.root {
+			continue
+		}
+		if err := rctx.removeWatch(path); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	for path, root := range rctx.polled {
+		if !strings.HasPrefix(path, prefix) || root {
+			continue
+		}
+		delete(rctx.polled, path)
+		// Without an ack, errors are reported to the error sink by the poller
+		//nolint:errcheck
+		rctx.poller.Remove(path)
+	}
+	return errs
+}
+
+// isLimitError returns true if err means that no more watches can be added
+func isLimitError(err error) bool {
+	var limitErr *LimitError
+	return errors.As(err, &limitErr)
+}
+
+// fallback polls a path that could not be watched using inotify. It
+// waits until the poller has taken its first look at the path, so that
+// changes made afterwards are not missed, unless Run() is returning.
+// The poll fallback must be enabled, and rctx.mu must be held by the
+// caller.
+func (rctx *runCtx) fallback(path string, ops api.OpMask, recursive bool, exclude func(string) bool, root bool) error {
+	options := []api.CommandOption{api.WithOpMask(ops), api.WithRecursive(recursive), api.WithAck(true), api.WithContext(rctx.ctx)}
+	if exclude != nil {
+		options = append(options, api.WithExcludeDir(exclude))
+	}
+	if err := rctx.poller.Add(path, options...); err != nil {
+		return err
+	}
+	rctx.polled[path] = rctx.polled[path] || root
+	return nil
+}
+
+// removeWatch removes the inotify watch for a single path. rctx.mu
+// must be held by the caller.
+func (rctx *runCtx) removeWatch(path string) error {
+	watchEntry, ok := rctx.watches[path]
+	if !ok {
+		return nil
+	}
+	delete(rctx.watches, path)
+	delete(rctx.paths, int(watchEntry.wd))
+
+	// EINVAL means that the kernel has already removed the watch
+	// (e.g. because the file is gone)
+	if success, errno := unix.InotifyRmWatch(rctx.infd, watchEntry.wd); success == -1 && errno != unix.EINVAL {
+		return newError("remove", path, errno)
+	}
+	return nil
+}
+
+// doEpoll reads and dispatches events until ctx is done. It returns
+// a fatal error if waiting for events fails.
+func (rctx *runCtx) doEpoll(ctx context.Context) error {
 	events := make([]unix.EpollEvent, 7)
 	for {
 		select {
 		case <-ctx.Done():
-			return
+			return nil
 		default:
 		}
 
 		// Note: if the context is marked done while waiting for EpollWait,
 		// the main Driver loop should send us a notification.
-		n, err := unix.EpollWait(rctx.epfd, events, -1)
+		n, err := unix.EpollWait(rctx.epfd, events, rctx.movesTimeout())
+
+		// Unmatched moves must be reported even if nothing else happens
+		rctx.flushMoves(time.Now())
+
 		if n == -1 {
 			if err == unix.EINTR {
 				continue
 			}
+			return newFatalError("read", fmt.Errorf(`failed to wait for events: %w`, err))
 		}
 
 		if n == 0 {
@@ -249,7 +784,7 @@ func (rctx *runCtx) doEpoll(ctx context.Context) {
 		}
 
 		if n > 6 {
-			rctx.errsink.Error(fmt.Errorf(`epoll_wait returned %d events (expected less than 6)`, n))
+			rctx.errsink.Error(newError("read", "", fmt.Errorf(`epoll_wait returned %d events (expected less than 6)`, n)))
 			continue
 		}
 
@@ -267,7 +802,7 @@ func (rctx *runCtx) doEpoll(ctx context.Context) {
 			case int32(rctx.wakeupfd):
 				if event.Events&unix.EPOLLIN != 0 {
 					if err := rctx.epollWakeClear(); err != nil {
-						rctx.errsink.Error(err)
+						rctx.errsink.Error(newError("wakeup", "", err))
 					}
 				}
 			}
@@ -288,17 +823,17 @@ func (rctx *runCtx) doEpoll(ctx context.Context) {
 
 		select {
 		case <-ctx.Done():
-			return
+			return nil
 		default:
 		}
 
 		if n < unix.SizeofInotifyEvent {
 			if n == 0 {
-				rctx.errsink.Error(io.EOF)
+				rctx.errsink.Error(newError("read", "", io.EOF))
 			} else if n < 0 {
-				rctx.errsink.Error(err)
+				rctx.errsink.Error(newError("read", "", err))
 			} else {
-				rctx.errsink.Error(fmt.Errorf(`short read while reading events`))
+				rctx.errsink.Error(newError("read", "", fmt.Errorf(`short read while reading events`)))
 			}
 			continue
 		}
@@ -310,7 +845,11 @@ func (rctx *runCtx) doEpoll(ctx context.Context) {
 			nameLen := uint32(raw.Len)
 
 			if rawMask&unix.IN_Q_OVERFLOW != 0 {
-				rctx.errsink.Error(ErrEventOverflow)
+				if rctx.snapshot != nil {
+					rctx.recoverOverflow()
+				} else {
+					rctx.errsink.Error(newError("read", "", ErrEventOverflow))
+				}
 			}
 
 			// If the event happened to the watched directory or the watched file, the kernel
@@ -319,6 +858,15 @@ func (rctx *runCtx) doEpoll(ctx context.Context) {
 			// the "paths" map.
 			rctx.mu.Lock()
 			name, ok := rctx.paths[int(raw.Wd)]
+			var ops api.OpMask
+			var recursive bool
+			var exclude func(string) bool
+			if ok {
+				watchEntry := rctx.watches[name]
+				ops = watchEntry.ops
+				recursive = watchEntry.recursive
+				exclude = watchEntry.exclude
+			}
 			// IN_DELETE_SELF occurs when the file/directory being watched is removed.
 			// This is a sign to clean up the maps, otherwise we are no longer in sync
 			// with the inotify kernel state which has already deleted the watch
@@ -329,23 +877,219 @@ func (rctx *runCtx) doEpoll(ctx context.Context) {
 			}
 			rctx.mu.Unlock()
 
+			// Move to the next event in the buffer
+			offset += unix.SizeofInotifyEvent + nameLen
+
+			// The watch has already been removed (or this is an overflow event)
+			if !ok {
+				continue
+			}
+			dir := name
+
+			// Files that follow their inodes take care of their own moves
+			if nameLen == 0 && rawMask&inodeFlags != 0 && rctx.followInode(name, rawMask) {
+				continue
+			}
+
 			if nameLen > 0 {
 				// Point "bytes" at the first byte of the filename
-				bytes := (*[unix.PathMax]byte)(unsafe.Pointer(&buf[offset+unix.SizeofInotifyEvent]))[:nameLen:nameLen]
+				bytes := (*[unix.PathMax]byte)(unsafe.Pointer(&buf[offset-nameLen]))[:nameLen:nameLen]
 				// The filename is padded with NULL bytes. TrimRight() gets rid of those.
 				name += "/" + strings.TrimRight(string(bytes[0:nameLen]), "\000")
 			}
 
-			mask := newOpMask(rawMask)
-			if !ignoreLinux(name, mask, rawMask) {
-				event := api.NewEvent(name, mask)
-				rctx.evsink.Event(event)
+			// Update the watches before the event is delivered, so that
+			// the receiver sees a consistent state
+			var created []string
+			var errs []error
+			if recursive && nameLen > 0 && rawMask&unix.IN_ISDIR == unix.IN_ISDIR {
+				created, errs = rctx.updateTree(name, rawMask)
+			}
+			if rctx.snapshot != nil && ops != 0 && rawMask&snapshotFlags != 0 {
+				rctx.mu.Lock()
+				errs = append(errs, newScanErrors(rctx.snapshot.Update(name, snapshot.WithRecursive(recursive), snapshot.WithExcludeDir(exclude)))...)
+				rctx.mu.Unlock()
 			}
 
-			// Move to the next event in the buffer
-			offset += unix.SizeofInotifyEvent + nameLen
+			switch {
+			case rawMask&unix.IN_MOVED_FROM == unix.IN_MOVED_FROM:
+				// Hold on to the event until we know where the file went
+				rctx.moves = append(rctx.moves, &move{
+					cookie:   raw.Cookie,
+					name:     name,
+					ops:      ops,
+					deadline: time.Now().Add(moveTimeout),
+				})
+			case rawMask&unix.IN_MOVED_TO == unix.IN_MOVED_TO:
+				rctx.pairMove(raw.Cookie, name, ops)
+			default:
+				// Only report the operations that were requested for this watch
+				mask := newOpMask(rawMask) & ops
+				if mask != 0 && !ignoreLinux(name, mask, rawMask) {
+					event := api.NewEvent(name, mask)
+					rctx.evsink.Event(event)
+				}
+			}
+
+			if ops.IsSet(api.OpCreate) {
+				for _, path := range created {
+					rctx.evsink.Event(api.NewEvent(path, api.OpMask(api.OpCreate)))
+				}
+			}
+			for _, err := range errs {
+				rctx.errsink.Error(err)
+			}
+
+			rctx.updatePending(dir, name, rawMask)
+			rctx.updateFollows(dir, name, rawMask)
+		}
+	}
+}
+
+// snapshotFlags are the inotify flags for events that may change the
+// state that is kept in the snapshot
+const snapshotFlags = unix.IN_CREATE | unix.IN_DELETE | unix.IN_DELETE_SELF | unix.IN_MODIFY |
+	unix.IN_ATTRIB | unix.IN_CLOSE_WRITE | unix.IN_MOVED_FROM | unix.IN_MOVED_TO | unix.IN_MOVE_SELF
+
+// scan takes a snapshot of all the paths that were explicitly
+// requested to be watched. rctx.mu must be held by the caller.
+func (rctx *runCtx) scan() (snapshot.Snapshot, []error) {
+	snap := make(snapshot.Snapshot)
+	var errs []error
+	for path, watchEntry := range rctx.watches {
+		if watchEntry.root {
+			errs = append(errs, newScanErrors(snap.Add(path, watchEntry.scanOptions()...))...)
 		}
 	}
+	return snap, errs
+}
+
+// recoverOverflow is called when the kernel event queue overflowed.
+// It reports the overflow, brings the watches back in sync with the
+// file system, and reports the changes that were found by comparing
+// the snapshot with the current state of the watched paths.
+func (rctx *runCtx) recoverOverflow() {
+	// The other halves of pending moves may have been lost
+	rctx.flushMoves(time.Now().Add(moveTimeout))
+
+	rctx.evsink.Event(api.NewEvent("", api.OpMask(api.OpOverflow)))
+
+	rctx.mu.Lock()
+	// Directories may have come and gone without us knowing. New ones
+	// are watched before the changes are computed, so that their
+	// contents are reported according to their watches. Watches for
+	// directories that are gone are dropped afterwards for the same reason.
+	var errs []error
+	for path, watchEntry := range rctx.watches {
+		if watchEntry.root && watchEntry.recursive {
+			_, treeErrs := rctx.addTree(path, watchEntry.ops, watchEntry.exclude)
+			errs = append(errs, treeErrs...)
+		}
+	}
+
+	cur, scanErrs := rctx.scan()
+	errs = append(errs, scanErrs...)
+
+	var events []api.Event
+	for _, ev := range snapshot.Diff(rctx.snapshot, cur) {
+		ops := rctx.opsFor(ev.Name())
+		if rev, ok := ev.(api.RenameEvent); ok {
+			ops |= rctx.opsFor(rev.OldName())
+		}
+		events = append(events, snapshot.Filter(ev, ops)...)
+	}
+
+	for path, watchEntry := range rctx.watches {
+		if watchEntry.root || watchEntry.anchors > 0 {
+			continue
+		}
+		if _, ok := cur[path]; !ok {
+			if err := rctx.removeWatch(path); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	rctx.snapshot = cur
+	rctx.mu.Unlock()
+
+	for _, ev := range events {
+		rctx.evsink.Event(ev)
+	}
+	for _, err := range errs {
+		rctx.errsink.Error(err)
+	}
+
+	// Pending targets may have been created, and targets that follow
+	// their names may have been replaced while events were lost
+	rctx.retryPending()
+	rctx.retryFollows()
+}
+
+// opsFor returns the operations that are reported for events about
+// the given path: those of the directory that contains it if it is
+// watched, or those of the path itself. rctx.mu must be held by the caller.
+func (rctx *runCtx) opsFor(path string) api.OpMask {
+	if watchEntry, ok := rctx.watches[filepath.Dir(path)]; ok {
+		return watchEntry.ops
+	}
+	if watchEntry, ok := rctx.watches[path]; ok {
+		return watchEntry.ops
+	}
+	return 0
+}
+
+// updateTree keeps the set of watches in sync when a directory is
+// created, moved or removed inside a recursively watched directory.
+// It returns the entries that were found in a newly created directory,
+// and the errors that were encountered along the way.
+func (rctx *runCtx) updateTree(dir string, rawMask uint32) ([]string, []error) {
+	rctx.mu.Lock()
+	defer rctx.mu.Unlock()
+
+	switch {
+	case rawMask&(unix.IN_CREATE|unix.IN_MOVED_TO) != 0:
+		// New directories are watched in the same way as their parent.
+		// The parent may have been removed since the event was read.
+		parent, ok := rctx.watches[filepath.Dir(dir)]
+		if !ok {
+			return nil, nil
+		}
+		if parent.exclude != nil && parent.exclude(dir) {
+			return nil, nil
+		}
+		if err := rctx.addWatch(dir, parent.ops, true, false); err != nil {
+			if os.IsNotExist(err) {
+				return nil, nil
+			}
+			errs := []error{newError("add", dir, err)}
+			if isLimitError(err) && rctx.poller != nil {
+				if err := rctx.fallback(dir, parent.ops, true, parent.exclude, false); err != nil {
+					errs = append(errs, err)
+				}
+			}
+			return nil, errs
+		}
+		rctx.watches[dir].exclude = parent.exclude
+
+		// Entries may have been created in a new directory before we
+		// managed to watch it. Report them so that they are not lost.
+		// Directories that were moved in are reported as a whole.
+		found, errs := rctx.addTree(dir, parent.ops, parent.exclude)
+		if rawMask&unix.IN_CREATE != unix.IN_CREATE {
+			found = nil
+		}
+		return found, errs
+	case rawMask&unix.IN_MOVED_FROM == unix.IN_MOVED_FROM:
+		if watchEntry, ok := rctx.watches[dir]; !ok || watchEntry.root {
+			return nil, nil
+		}
+		errs := rctx.removeTree(dir)
+		if err := rctx.removeWatch(dir); err != nil {
+			errs = append(errs, err)
+		}
+		return nil, errs
+	}
+	return nil, nil
 }
 
 func newOpMask(rawMask uint32) api.OpMask {
@@ -353,21 +1097,71 @@ func newOpMask(rawMask uint32) api.OpMask {
 	if rawMask&unix.IN_CREATE == unix.IN_CREATE || rawMask&unix.IN_MOVED_TO == unix.IN_MOVED_TO {
 		mask.Set(api.OpCreate)
 	}
-	if rawMask&unix.IN_DELETE_SELF == unix.IN_DELETE_SELF || rawMask&unix.IN_DELETE == unix.IN_DELETE {
+	// A file that is moved out of a watched directory is as good as removed.
+	// If it was moved to a watched directory, the two halves of the move are
+	// reported as a single rename event instead (see pairMove)
+	if rawMask&unix.IN_DELETE_SELF == unix.IN_DELETE_SELF || rawMask&unix.IN_DELETE == unix.IN_DELETE || rawMask&unix.IN_MOVED_FROM == unix.IN_MOVED_FROM {
 		mask.Set(api.OpRemove)
 	}
 	if rawMask&unix.IN_MODIFY == unix.IN_MODIFY {
 		mask.Set(api.OpWrite)
 	}
-	if rawMask&unix.IN_MOVE_SELF == unix.IN_MOVE_SELF || rawMask&unix.IN_MOVED_FROM == unix.IN_MOVED_FROM {
+	if rawMask&unix.IN_MOVE_SELF == unix.IN_MOVE_SELF {
 		mask.Set(api.OpRename)
 	}
 	if rawMask&unix.IN_ATTRIB == unix.IN_ATTRIB {
 		mask.Set(api.OpChmod)
 	}
+	if rawMask&unix.IN_CLOSE_WRITE == unix.IN_CLOSE_WRITE {
+		mask.Set(api.OpCloseWrite)
+	}
+	if rawMask&unix.IN_CLOSE_NOWRITE == unix.IN_CLOSE_NOWRITE {
+		mask.Set(api.OpCloseNoWrite)
+	}
+	if rawMask&unix.IN_OPEN == unix.IN_OPEN {
+		mask.Set(api.OpOpen)
+	}
+	if rawMask&unix.IN_ACCESS == unix.IN_ACCESS {
+		mask.Set(api.OpAccess)
+	}
 	return mask
 }
 
+// newInotifyFlags returns the minimum set of inotify flags that are
+// required to detect the operations in mask. It is the inverse of newOpMask
+func newInotifyFlags(mask api.OpMask) uint32 {
+	var flags uint32
+	if mask.IsSet(api.OpCreate) {
+		flags |= unix.IN_CREATE | unix.IN_MOVED_TO
+	}
+	if mask.IsSet(api.OpRemove) {
+		flags |= unix.IN_DELETE | unix.IN_DELETE_SELF | unix.IN_MOVED_FROM
+	}
+	if mask.IsSet(api.OpWrite) {
+		flags |= unix.IN_MODIFY
+	}
+	if mask.IsSet(api.OpRename) {
+		// Both halves of a move are required to pair them
+		flags |= unix.IN_MOVE_SELF | unix.IN_MOVED_FROM | unix.IN_MOVED_TO
+	}
+	if mask.IsSet(api.OpChmod) {
+		flags |= unix.IN_ATTRIB
+	}
+	if mask.IsSet(api.OpCloseWrite) {
+		flags |= unix.IN_CLOSE_WRITE
+	}
+	if mask.IsSet(api.OpCloseNoWrite) {
+		flags |= unix.IN_CLOSE_NOWRITE
+	}
+	if mask.IsSet(api.OpOpen) {
+		flags |= unix.IN_OPEN
+	}
+	if mask.IsSet(api.OpAccess) {
+		flags |= unix.IN_ACCESS
+	}
+	return flags
+}
+
 // Certain types of events can be "ignored" and not sent over the Events
 // channel. Such as events marked ignore by the kernel, or MODIFY events
 // against files that do not exist.
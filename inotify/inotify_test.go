@@ -4,9 +4,770 @@
 package inotify_test
 
 import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
 	"github.com/lestrrat-go/fsnotify/api"
+	"github.com/lestrrat-go/fsnotify/drivertest"
 	"github.com/lestrrat-go/fsnotify/inotify"
+	"github.com/stretchr/testify/assert"
 )
 
 // Sanity
 var _ api.Driver = &inotify.Driver{}
+
+func TestConformance(t *testing.T) {
+	var ops api.OpMask
+	for _, op := range []api.Op{api.OpCloseWrite, api.OpCloseNoWrite, api.OpOpen, api.OpAccess} {
+		ops.Set(op)
+	}
+	drivertest.Run(t, func(_ *testing.T) api.Driver {
+		return inotify.New()
+	}, drivertest.WithSupportedOps(api.DefaultOpMask|ops))
+}
+
+type chanEventSink chan api.Event
+
+func (sink chanEventSink) Event(ev api.Event) {
+	sink <- ev
+}
+
+// startDriver starts the driver in the background, and waits for it
+// to become ready
+func startDriver(ctx context.Context, t *testing.T, driver *inotify.Driver) (chan api.Event, chan error) {
+	t.Helper()
+
+	evCh := make(chan api.Event, 128)
+	errCh := make(chan error, 128)
+	ready := make(chan struct{})
+	go driver.Run(ctx, ready, chanEventSink(evCh), api.ChanErrSink(errCh))
+
+	select {
+	case <-ready:
+	case <-time.After(5 * time.Second):
+		t.Fatal(`driver did not become ready`)
+	}
+	return evCh, errCh
+}
+
+// waitEvent waits until an event for the given name with the given
+// operation is received
+func waitEvent(t *testing.T, evCh chan api.Event, name string, op api.Op) bool {
+	t.Helper()
+
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case ev := <-evCh:
+			if ev.Name() == name && ev.Mask().IsSet(op) {
+				return true
+			}
+		case <-timeout:
+			return assert.Fail(t, `timed out waiting for event`, `expected %s for %q`, op, name)
+		}
+	}
+}
+
+func TestRecursive(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fsnotify-inotify-test-*")
+	if !assert.NoError(t, err, `ioutil.TempDir should succeed`) {
+		return
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	existing := filepath.Join(dir, "a", "b")
+	if !assert.NoError(t, os.MkdirAll(existing, 0755), `os.MkdirAll should succeed`) {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	driver := inotify.New()
+	evCh, _ := startDriver(ctx, t, driver)
+
+	if !assert.NoError(t, driver.Add(dir, api.WithRecursive(true), api.WithAck(true)), `driver.Add should succeed`) {
+		return
+	}
+
+	t.Run("Existing directory", func(t *testing.T) {
+		name := filepath.Join(existing, "file")
+		if !assert.NoError(t, ioutil.WriteFile(name, []byte(`Hello`), 0644), `ioutil.WriteFile should succeed`) {
+			return
+		}
+		waitEvent(t, evCh, name, api.OpCreate)
+	})
+	t.Run("New directory", func(t *testing.T) {
+		created := filepath.Join(dir, "c", "d")
+		if !assert.NoError(t, os.MkdirAll(created, 0755), `os.MkdirAll should succeed`) {
+			return
+		}
+		// The file may be created before the driver had a chance to
+		// watch the directory, but it should be reported either way
+		name := filepath.Join(created, "file")
+		if !assert.NoError(t, ioutil.WriteFile(name, []byte(`Hello`), 0644), `ioutil.WriteFile should succeed`) {
+			return
+		}
+		waitEvent(t, evCh, name, api.OpCreate)
+	})
+	t.Run("Moved out directory", func(t *testing.T) {
+		outside, err := ioutil.TempDir("", "fsnotify-inotify-test-*")
+		if !assert.NoError(t, err, `ioutil.TempDir should succeed`) {
+			return
+		}
+		t.Cleanup(func() { os.RemoveAll(outside) })
+
+		moved := filepath.Join(outside, "a")
+		if !assert.NoError(t, os.Rename(filepath.Join(dir, "a"), moved), `os.Rename should succeed`) {
+			return
+		}
+		// The directory was moved to a place that we are not
+		// watching, so it should be reported as removed
+		waitEvent(t, evCh, filepath.Join(dir, "a"), api.OpRemove)
+
+		// Changes in the moved directory should no longer be reported,
+		// but changes in the watched tree still should
+		if !assert.NoError(t, ioutil.WriteFile(filepath.Join(moved, "b", "file2"), []byte(`Hello`), 0644), `ioutil.WriteFile should succeed`) {
+			return
+		}
+		name := filepath.Join(dir, "sentinel")
+		if !assert.NoError(t, ioutil.WriteFile(name, []byte(`Hello`), 0644), `ioutil.WriteFile should succeed`) {
+			return
+		}
+
+		timeout := time.After(5 * time.Second)
+		for {
+			select {
+			case ev := <-evCh:
+				if !assert.False(t, strings.HasPrefix(ev.Name(), filepath.Join(dir, "a")+"/"), `events from the moved directory should not be reported (got %q)`, ev.Name()) {
+					return
+				}
+				if ev.Name() == name {
+					return
+				}
+			case <-timeout:
+				assert.Fail(t, `timed out waiting for event`)
+				return
+			}
+		}
+	})
+	t.Run("Remove", func(t *testing.T) {
+		if !assert.NoError(t, driver.Remove(dir, api.WithAck(true)), `driver.Remove should succeed`) {
+			return
+		}
+		if !assert.Error(t, driver.Remove(dir, api.WithAck(true)), `driver.Remove on a removed path should fail`) {
+			return
+		}
+	})
+}
+
+func TestOpMask(t *testing.T) {
+	f, err := ioutil.TempFile("", "fsnotify-inotify-test-*")
+	if !assert.NoError(t, err, `ioutil.TempFile should succeed`) {
+		return
+	}
+	t.Cleanup(func() { os.Remove(f.Name()) })
+	defer f.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	driver := inotify.New()
+	evCh, _ := startDriver(ctx, t, driver)
+
+	if !assert.NoError(t, driver.Add(f.Name(), api.WithOpMask(api.OpMask(api.OpChmod)), api.WithAck(true)), `driver.Add should succeed`) {
+		return
+	}
+
+	t.Run("Filtered", func(t *testing.T) {
+		// The write should not be reported, so the first event
+		// that we see should be the chmod
+		if !assert.NoError(t, ioutil.WriteFile(f.Name(), []byte(`Hello`), 0600), `ioutil.WriteFile should succeed`) {
+			return
+		}
+		if !assert.NoError(t, os.Chmod(f.Name(), 0644), `os.Chmod should succeed`) {
+			return
+		}
+
+		select {
+		case ev := <-evCh:
+			if !assert.Equal(t, api.OpMask(api.OpChmod), ev.Mask(), `only CHMOD should be reported`) {
+				return
+			}
+		case <-time.After(5 * time.Second):
+			assert.Fail(t, `timed out waiting for event`)
+		}
+	})
+	t.Run("Merged", func(t *testing.T) {
+		if !assert.NoError(t, driver.Add(f.Name(), api.WithOpMask(api.OpMask(api.OpWrite)), api.WithAck(true)), `driver.Add should succeed`) {
+			return
+		}
+		if !assert.NoError(t, ioutil.WriteFile(f.Name(), []byte(`Hello`), 0644), `ioutil.WriteFile should succeed`) {
+			return
+		}
+		waitEvent(t, evCh, f.Name(), api.OpWrite)
+
+		if !assert.NoError(t, os.Chmod(f.Name(), 0600), `os.Chmod should succeed`) {
+			return
+		}
+		waitEvent(t, evCh, f.Name(), api.OpChmod)
+	})
+}
+
+func TestRename(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fsnotify-inotify-test-*")
+	if !assert.NoError(t, err, `ioutil.TempDir should succeed`) {
+		return
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	outside, err := ioutil.TempDir("", "fsnotify-inotify-test-*")
+	if !assert.NoError(t, err, `ioutil.TempDir should succeed`) {
+		return
+	}
+	t.Cleanup(func() { os.RemoveAll(outside) })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	driver := inotify.New()
+	evCh, _ := startDriver(ctx, t, driver)
+
+	if !assert.NoError(t, driver.Add(dir, api.WithRecursive(true), api.WithAck(true)), `driver.Add should succeed`) {
+		return
+	}
+
+	sub := filepath.Join(dir, "sub")
+	if !assert.NoError(t, os.Mkdir(sub, 0755), `os.Mkdir should succeed`) {
+		return
+	}
+	waitEvent(t, evCh, sub, api.OpCreate)
+
+	t.Run("Moved in", func(t *testing.T) {
+		src := filepath.Join(outside, "file")
+		if !assert.NoError(t, ioutil.WriteFile(src, []byte(`Hello`), 0644), `ioutil.WriteFile should succeed`) {
+			return
+		}
+		if !assert.NoError(t, os.Rename(src, filepath.Join(dir, "file")), `os.Rename should succeed`) {
+			return
+		}
+		waitEvent(t, evCh, filepath.Join(dir, "file"), api.OpCreate)
+	})
+	t.Run("Moved within", func(t *testing.T) {
+		dst := filepath.Join(sub, "renamed")
+		if !assert.NoError(t, os.Rename(filepath.Join(dir, "file"), dst), `os.Rename should succeed`) {
+			return
+		}
+
+		select {
+		case ev := <-evCh:
+			rev, ok := ev.(api.RenameEvent)
+			if !assert.True(t, ok, `event should be an api.RenameEvent (got %s)`, ev) {
+				return
+			}
+			if !assert.Equal(t, api.OpMask(api.OpRename), rev.Mask(), `mask should be RENAME`) {
+				return
+			}
+			if !assert.Equal(t, filepath.Join(dir, "file"), rev.OldName(), `old name should match`) {
+				return
+			}
+			if !assert.Equal(t, dst, rev.Name(), `new name should match`) {
+				return
+			}
+		case <-time.After(5 * time.Second):
+			assert.Fail(t, `timed out waiting for event`)
+		}
+	})
+	t.Run("Moved out", func(t *testing.T) {
+		src := filepath.Join(sub, "renamed")
+		if !assert.NoError(t, os.Rename(src, filepath.Join(outside, "file")), `os.Rename should succeed`) {
+			return
+		}
+		waitEvent(t, evCh, src, api.OpRemove)
+	})
+}
+
+func TestOptInOps(t *testing.T) {
+	f, err := ioutil.TempFile("", "fsnotify-inotify-test-*")
+	if !assert.NoError(t, err, `ioutil.TempFile should succeed`) {
+		return
+	}
+	t.Cleanup(func() { os.Remove(f.Name()) })
+	f.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	driver := inotify.New()
+	evCh, _ := startDriver(ctx, t, driver)
+
+	var mask api.OpMask
+	mask.Set(api.OpCloseWrite)
+	mask.Set(api.OpCloseNoWrite)
+	mask.Set(api.OpOpen)
+	mask.Set(api.OpAccess)
+	if !assert.NoError(t, driver.Add(f.Name(), api.WithOpMask(mask), api.WithAck(true)), `driver.Add should succeed`) {
+		return
+	}
+
+	if !assert.NoError(t, ioutil.WriteFile(f.Name(), []byte(`Hello`), 0644), `ioutil.WriteFile should succeed`) {
+		return
+	}
+	waitEvent(t, evCh, f.Name(), api.OpOpen)
+	waitEvent(t, evCh, f.Name(), api.OpCloseWrite)
+
+	if _, err := ioutil.ReadFile(f.Name()); !assert.NoError(t, err, `ioutil.ReadFile should succeed`) {
+		return
+	}
+	waitEvent(t, evCh, f.Name(), api.OpAccess)
+	waitEvent(t, evCh, f.Name(), api.OpCloseNoWrite)
+}
+
+func TestExcludeDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fsnotify-inotify-test-*")
+	if !assert.NoError(t, err, `ioutil.TempDir should succeed`) {
+		return
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	excluded := filepath.Join(dir, "excluded")
+	if !assert.NoError(t, os.Mkdir(excluded, 0755), `os.Mkdir should succeed`) {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	driver := inotify.New()
+	evCh, _ := startDriver(ctx, t, driver)
+
+	exclude := func(path string) bool {
+		return filepath.Base(path) == "excluded"
+	}
+	if !assert.NoError(t, driver.Add(dir, api.WithRecursive(true), api.WithExcludeDir(exclude), api.WithAck(true)), `driver.Add should succeed`) {
+		return
+	}
+
+	// Create an excluded directory below a directory that is created
+	// after the watch was set up, to make sure that the exclusion is
+	// inherited by new directories
+	created := filepath.Join(dir, "sub", "excluded")
+	if !assert.NoError(t, os.MkdirAll(created, 0755), `os.MkdirAll should succeed`) {
+		return
+	}
+	waitEvent(t, evCh, created, api.OpCreate)
+
+	for _, d := range []string{excluded, created} {
+		if !assert.NoError(t, ioutil.WriteFile(filepath.Join(d, "file"), []byte(`Hello`), 0644), `ioutil.WriteFile should succeed`) {
+			return
+		}
+	}
+	name := filepath.Join(dir, "sub", "sentinel")
+	if !assert.NoError(t, ioutil.WriteFile(name, []byte(`Hello`), 0644), `ioutil.WriteFile should succeed`) {
+		return
+	}
+
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case ev := <-evCh:
+			if !assert.NotEqual(t, "file", filepath.Base(ev.Name()), `events from excluded directories should not be reported (got %q)`, ev.Name()) {
+				return
+			}
+			if ev.Name() == name {
+				return
+			}
+		case <-timeout:
+			assert.Fail(t, `timed out waiting for event`)
+			return
+		}
+	}
+}
+
+func TestOverflowRecovery(t *testing.T) {
+	limits, err := inotify.ReadLimits()
+	if err != nil {
+		t.Skipf(`failed to read inotify limits: %s`, err)
+	}
+	maxQueued := limits.MaxQueuedEvents
+
+	dir, err := ioutil.TempDir("", "fsnotify-inotify-test-*")
+	if !assert.NoError(t, err, `ioutil.TempDir should succeed`) {
+		return
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	driver := inotify.New(inotify.WithOverflowRecovery(true))
+	evCh, errCh := startDriver(ctx, t, driver)
+
+	if !assert.NoError(t, driver.Add(dir, api.WithOpMask(api.OpMask(api.OpCreate)), api.WithAck(true)), `driver.Add should succeed`) {
+		return
+	}
+
+	// Nobody is reading events yet, so the driver blocks as soon as
+	// the event channel is full, and the kernel queue overflows
+	count := maxQueued + 1024
+	for i := 0; i < count; i++ {
+		f, err := os.Create(filepath.Join(dir, strconv.Itoa(i)))
+		if !assert.NoError(t, err, `os.Create should succeed`) {
+			return
+		}
+		f.Close()
+	}
+
+	// Every file must be reported, either by a live event or by the
+	// rescan that follows the overflow
+	var overflowed bool
+	seen := make(map[string]struct{})
+	timeout := time.After(30 * time.Second)
+	for len(seen) < count || !overflowed {
+		select {
+		case ev := <-evCh:
+			if ev.Mask().IsSet(api.OpOverflow) {
+				overflowed = true
+				continue
+			}
+			if !assert.Equal(t, api.OpMask(api.OpCreate), ev.Mask(), `only CREATE should be reported (got %s)`, ev) {
+				return
+			}
+			seen[ev.Name()] = struct{}{}
+		case err := <-errCh:
+			assert.NoError(t, err, `no errors should be reported`)
+			return
+		case <-timeout:
+			assert.Fail(t, `timed out waiting for events`, `overflowed: %t, seen %d/%d`, overflowed, len(seen), count)
+			return
+		}
+	}
+}
+
+func TestWatchLimit(t *testing.T) {
+	limits, err := inotify.ReadLimits()
+	if err != nil {
+		t.Skipf(`failed to read inotify limits: %s`, err)
+	}
+	if !assert.True(t, limits.MaxUserWatches > 0 && limits.MaxUserInstances > 0 && limits.MaxQueuedEvents > 0, `limits should be positive`) {
+		return
+	}
+
+	dir, err := ioutil.TempDir("", "fsnotify-inotify-test-*")
+	if !assert.NoError(t, err, `ioutil.TempDir should succeed`) {
+		return
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	full := filepath.Join(dir, "full")
+	if !assert.NoError(t, os.Mkdir(full, 0755), `os.Mkdir should succeed`) {
+		return
+	}
+	defer inotify.FailAddWatch(func(path string) bool { return path == full })()
+
+	checkErr := func(t *testing.T, err error) bool {
+		t.Helper()
+		if !assert.ErrorIs(t, err, api.ErrWatchLimit, `error should be classified as api.ErrWatchLimit`) {
+			return false
+		}
+		var limitErr *inotify.LimitError
+		if !assert.ErrorAs(t, err, &limitErr, `error should be an *inotify.LimitError`) {
+			return false
+		}
+		return assert.Equal(t, "max_user_watches", limitErr.Name, `limit name should match`) &&
+			assert.Equal(t, limits.MaxUserWatches, limitErr.Max, `limit value should match`)
+	}
+
+	t.Run("Without fallback", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		driver := inotify.New()
+		startDriver(ctx, t, driver)
+
+		if !checkErr(t, driver.Add(full, api.WithAck(true))) {
+			return
+		}
+		usage, err := driver.Usage()
+		if !assert.NoError(t, err, `driver.Usage should succeed`) {
+			return
+		}
+		if !assert.Equal(t, inotify.Usage{Limits: limits}, usage, `nothing should be in use`) {
+			return
+		}
+	})
+	t.Run("With fallback", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		driver := inotify.New(inotify.WithPollFallback(50 * time.Millisecond))
+		evCh, errCh := startDriver(ctx, t, driver)
+
+		if !assert.NoError(t, driver.Add(dir, api.WithRecursive(true), api.WithAck(true)), `driver.Add should succeed`) {
+			return
+		}
+		select {
+		case err := <-errCh:
+			if !checkErr(t, err) {
+				return
+			}
+		case <-time.After(5 * time.Second):
+			assert.Fail(t, `timed out waiting for error`)
+			return
+		}
+
+		usage, err := driver.Usage()
+		if !assert.NoError(t, err, `driver.Usage should succeed`) {
+			return
+		}
+		if !assert.Equal(t, inotify.Usage{Watches: 1, Polled: 1, Limits: limits}, usage, `usage should match`) {
+			return
+		}
+
+		name := filepath.Join(full, "file")
+		if !assert.NoError(t, ioutil.WriteFile(name, nil, 0644), `ioutil.WriteFile should succeed`) {
+			return
+		}
+		if !waitEvent(t, evCh, name, api.OpCreate) {
+			return
+		}
+
+		if !assert.NoError(t, driver.Remove(dir, api.WithAck(true)), `driver.Remove should succeed`) {
+			return
+		}
+		usage, err = driver.Usage()
+		if !assert.NoError(t, err, `driver.Usage should succeed`) {
+			return
+		}
+		if !assert.Equal(t, inotify.Usage{Limits: limits}, usage, `nothing should be in use after driver.Remove`) {
+			return
+		}
+	})
+}
+
+func TestPending(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fsnotify-inotify-test-*")
+	if !assert.NoError(t, err, `ioutil.TempDir should succeed`) {
+		return
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	driver := inotify.New()
+	evCh, _ := startDriver(ctx, t, driver)
+
+	target := filepath.Join(dir, "a", "b", "config")
+	if !assert.Error(t, driver.Add(target, api.WithAck(true)), `driver.Add should fail without api.WithPending`) {
+		return
+	}
+	if !assert.NoError(t, driver.Add(target, api.WithPending(true), api.WithAck(true)), `driver.Add should succeed`) {
+		return
+	}
+
+	// nextEvent returns the next event, which must be about the target
+	nextEvent := func(t *testing.T) api.Event {
+		t.Helper()
+		select {
+		case ev := <-evCh:
+			assert.Equal(t, target, ev.Name(), `event should be about the target (got %s)`, ev)
+			return ev
+		case <-time.After(5 * time.Second):
+			assert.Fail(t, `timed out waiting for event`)
+			return nil
+		}
+	}
+
+	t.Run("Created", func(t *testing.T) {
+		if !assert.NoError(t, os.MkdirAll(filepath.Dir(target), 0755), `os.MkdirAll should succeed`) {
+			return
+		}
+		if !assert.NoError(t, ioutil.WriteFile(target, nil, 0644), `ioutil.WriteFile should succeed`) {
+			return
+		}
+		ev := nextEvent(t)
+		if !assert.NotNil(t, ev) || !assert.Equal(t, api.OpMask(api.OpCreate), ev.Mask(), `target should be created`) {
+			return
+		}
+
+		if !assert.NoError(t, ioutil.WriteFile(target, []byte(`Hello`), 0644), `ioutil.WriteFile should succeed`) {
+			return
+		}
+		waitEvent(t, evCh, target, api.OpWrite)
+	})
+	t.Run("Removed and re-created", func(t *testing.T) {
+		if !assert.NoError(t, os.RemoveAll(filepath.Join(dir, "a")), `os.RemoveAll should succeed`) {
+			return
+		}
+		waitEvent(t, evCh, target, api.OpRemove)
+
+		if !assert.NoError(t, os.MkdirAll(filepath.Dir(target), 0755), `os.MkdirAll should succeed`) {
+			return
+		}
+		if !assert.NoError(t, ioutil.WriteFile(target, nil, 0644), `ioutil.WriteFile should succeed`) {
+			return
+		}
+		waitEvent(t, evCh, target, api.OpCreate)
+	})
+	t.Run("Remove", func(t *testing.T) {
+		if !assert.NoError(t, driver.Remove(target, api.WithAck(true)), `driver.Remove should succeed`) {
+			return
+		}
+		usage, err := driver.Usage()
+		if !assert.NoError(t, err, `driver.Usage should succeed`) {
+			return
+		}
+		if !assert.Equal(t, 0, usage.Watches, `no watches should be left`) {
+			return
+		}
+	})
+}
+
+func TestFollowName(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fsnotify-inotify-test-*")
+	if !assert.NoError(t, err, `ioutil.TempDir should succeed`) {
+		return
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	target := filepath.Join(dir, "config")
+	if !assert.NoError(t, ioutil.WriteFile(target, []byte(`v1`), 0644), `ioutil.WriteFile should succeed`) {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	driver := inotify.New()
+	evCh, _ := startDriver(ctx, t, driver)
+
+	if !assert.NoError(t, driver.Add(target, api.WithFollowName(true), api.WithAck(true)), `driver.Add should succeed`) {
+		return
+	}
+
+	t.Run("Replaced", func(t *testing.T) {
+		tmp := filepath.Join(dir, ".config.tmp")
+		if !assert.NoError(t, ioutil.WriteFile(tmp, []byte(`v2`), 0644), `ioutil.WriteFile should succeed`) {
+			return
+		}
+		if !assert.NoError(t, os.Rename(tmp, target), `os.Rename should succeed`) {
+			return
+		}
+
+		select {
+		case ev := <-evCh:
+			if !assert.Equal(t, api.NewEvent(target, api.OpMask(api.OpWrite)), ev, `a single WRITE event should be reported`) {
+				return
+			}
+		case <-time.After(5 * time.Second):
+			assert.Fail(t, `timed out waiting for event`)
+			return
+		}
+		select {
+		case ev := <-evCh:
+			assert.Fail(t, `no more events should be reported`, `got %s`, ev)
+			return
+		case <-time.After(100 * time.Millisecond):
+		}
+
+		// The new file is the one being watched
+		if !assert.NoError(t, ioutil.WriteFile(target, []byte(`v3`), 0644), `ioutil.WriteFile should succeed`) {
+			return
+		}
+		waitEvent(t, evCh, target, api.OpWrite)
+	})
+	t.Run("Removed and re-created", func(t *testing.T) {
+		if !assert.NoError(t, os.Remove(target), `os.Remove should succeed`) {
+			return
+		}
+		waitEvent(t, evCh, target, api.OpRemove)
+
+		if !assert.NoError(t, ioutil.WriteFile(target, []byte(`v4`), 0644), `ioutil.WriteFile should succeed`) {
+			return
+		}
+		waitEvent(t, evCh, target, api.OpCreate)
+	})
+	t.Run("Remove", func(t *testing.T) {
+		if !assert.NoError(t, driver.Remove(target, api.WithAck(true)), `driver.Remove should succeed`) {
+			return
+		}
+		usage, err := driver.Usage()
+		if !assert.NoError(t, err, `driver.Usage should succeed`) {
+			return
+		}
+		if !assert.Equal(t, 0, usage.Watches, `no watches should be left`) {
+			return
+		}
+	})
+}
+
+func TestFollowInode(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fsnotify-inotify-test-*")
+	if !assert.NoError(t, err, `ioutil.TempDir should succeed`) {
+		return
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	outside, err := ioutil.TempDir("", "fsnotify-inotify-test-*")
+	if !assert.NoError(t, err, `ioutil.TempDir should succeed`) {
+		return
+	}
+	t.Cleanup(func() { os.RemoveAll(outside) })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	driver := inotify.New()
+	evCh, _ := startDriver(ctx, t, driver)
+
+	name := filepath.Join(dir, "file")
+	if !assert.NoError(t, ioutil.WriteFile(name, nil, 0644), `ioutil.WriteFile should succeed`) {
+		return
+	}
+	if !assert.NoError(t, driver.Add(name, api.WithFollowInode(true), api.WithAck(true)), `driver.Add should succeed`) {
+		return
+	}
+
+	moved := filepath.Join(outside, "moved")
+	t.Run("Moved", func(t *testing.T) {
+		if !assert.NoError(t, os.Rename(name, moved), `os.Rename should succeed`) {
+			return
+		}
+
+		select {
+		case ev := <-evCh:
+			if !assert.Equal(t, api.NewRenameEvent(name, moved, api.OpMask(api.OpRename)), ev, `a rename event should be reported`) {
+				return
+			}
+		case <-time.After(5 * time.Second):
+			assert.Fail(t, `timed out waiting for event`)
+			return
+		}
+
+		if !assert.NoError(t, ioutil.WriteFile(moved, []byte(`Hello`), 0644), `ioutil.WriteFile should succeed`) {
+			return
+		}
+		waitEvent(t, evCh, moved, api.OpWrite)
+	})
+	t.Run("Removed", func(t *testing.T) {
+		if !assert.NoError(t, os.Remove(moved), `os.Remove should succeed`) {
+			return
+		}
+		waitEvent(t, evCh, moved, api.OpRemove)
+
+		usage, err := driver.Usage()
+		if !assert.NoError(t, err, `driver.Usage should succeed`) {
+			return
+		}
+		if !assert.Equal(t, 0, usage.Watches, `no watches should be left`) {
+			return
+		}
+	})
+}
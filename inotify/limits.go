@@ -0,0 +1,105 @@
+//go:build linux
+// +build linux
+
+package inotify
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// limitsDir is where the kernel exposes the limits on inotify resources
+const limitsDir = "/proc/sys/fs/inotify"
+
+// Limits are the limits that the kernel imposes on inotify resources.
+// They apply to each user, not to each driver.
+type Limits struct {
+	// MaxUserWatches is the maximum number of watches per user
+	MaxUserWatches int
+	// MaxUserInstances is the maximum number of inotify instances
+	// (i.e. running drivers) per user
+	MaxUserInstances int
+	// MaxQueuedEvents is the maximum number of events that are queued
+	// by the kernel before they overflow
+	MaxQueuedEvents int
+}
+
+// ReadLimits reads the current limits on inotify resources
+func ReadLimits() (Limits, error) {
+	var limits Limits
+	for _, limit := range []struct {
+		name string
+		dst  *int
+	}{
+		{name: "max_user_watches", dst: &limits.MaxUserWatches},
+		{name: "max_user_instances", dst: &limits.MaxUserInstances},
+		{name: "max_queued_events", dst: &limits.MaxQueuedEvents},
+	} {
+		v, err := readLimit(limit.name)
+		if err != nil {
+			return limits, err
+		}
+		*limit.dst = v
+	}
+	return limits, nil
+}
+
+func readLimit(name string) (int, error) {
+	buf, err := ioutil.ReadFile(filepath.Join(limitsDir, name))
+	if err != nil {
+		return 0, fmt.Errorf(`failed to read inotify limit %s: %w`, name, err)
+	}
+	v, err := strconv.Atoi(strings.TrimSpace(string(buf)))
+	if err != nil {
+		return 0, fmt.Errorf(`failed to parse inotify limit %s: %w`, name, err)
+	}
+	return v, nil
+}
+
+// Usage describes the inotify resources that are used by a driver
+type Usage struct {
+	// Watches is the number of inotify watches held by the driver
+	Watches int
+	// Polled is the number of paths that are being polled, because
+	// they could not be watched using inotify (see WithPollFallback)
+	Polled int
+	// Limits are the limits that were in effect when Usage was called
+	Limits Limits
+}
+
+// LimitError is the error that is reported when a limit on inotify
+// resources was reached. It is classified as api.ErrWatchLimit.
+type LimitError struct {
+	// Name is the name of the limit that was reached, e.g. "max_user_watches"
+	Name string
+	// Max is the value of the limit, or -1 if it could not be read
+	Max int
+	// Watches is the number of watches held by the driver at the time
+	Watches int
+	// Err is the error returned by the system call
+	Err error
+}
+
+func newLimitError(name string, watches int, err error) *LimitError {
+	limit, readErr := readLimit(name)
+	if readErr != nil {
+		limit = -1
+	}
+	return &LimitError{
+		Name:    name,
+		Max:     limit,
+		Watches: watches,
+		Err:     err,
+	}
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf(`%s (fs.inotify.%s is %d, the driver holds %d watches)`, e.Err, e.Name, e.Max, e.Watches)
+}
+
+func (e *LimitError) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,88 @@
+//go:build linux
+// +build linux
+
+package inotify
+
+import (
+	"time"
+
+	"github.com/lestrrat-go/fsnotify/api"
+)
+
+// moveTimeout is the amount of time that we wait for the IN_MOVED_TO
+// event that corresponds to an IN_MOVED_FROM event. The kernel queues
+// both halves of a move back to back, so this only needs to cover the
+// case where they are split between two reads.
+const moveTimeout = 50 * time.Millisecond
+
+// move is an IN_MOVED_FROM event that is waiting to be paired with
+// an IN_MOVED_TO event carrying the same cookie.
+type move struct {
+	cookie   uint32
+	name     string
+	ops      api.OpMask
+	deadline time.Time
+}
+
+// movesTimeout returns the timeout (in milliseconds) for epoll_wait,
+// so that we wake up in time to report unmatched moves.
+func (rctx *runCtx) movesTimeout() int {
+	if len(rctx.moves) == 0 {
+		return -1
+	}
+
+	// moves are stored in the order that they were received,
+	// so the first one is always the one that expires first
+	timeout := time.Until(rctx.moves[0].deadline)
+	if timeout <= 0 {
+		return 0
+	}
+	// round up, so that we do not wake up before the deadline
+	return int((timeout + time.Millisecond - 1) / time.Millisecond)
+}
+
+// flushMoves reports the moves that have not been paired before their
+// deadline. The files have been moved to a place that we are not watching,
+// so from our point of view they have been removed.
+func (rctx *runCtx) flushMoves(now time.Time) {
+	var i int
+	for ; i < len(rctx.moves); i++ {
+		m := rctx.moves[i]
+		if m.deadline.After(now) {
+			break
+		}
+		if m.ops.IsSet(api.OpRemove) {
+			rctx.evsink.Event(api.NewEvent(m.name, api.OpMask(api.OpRemove)))
+		}
+	}
+	rctx.moves = rctx.moves[i:]
+}
+
+// pairMove handles an IN_MOVED_TO event. If the corresponding
+// IN_MOVED_FROM event was seen, a single rename event is reported.
+// Otherwise, the file was moved in from a place that we are not
+// watching, so from our point of view it has been created.
+func (rctx *runCtx) pairMove(cookie uint32, name string, ops api.OpMask) {
+	var from *move
+	for i, m := range rctx.moves {
+		if m.cookie == cookie {
+			from = m
+			rctx.moves = append(rctx.moves[:i], rctx.moves[i+1:]...)
+			break
+		}
+	}
+
+	if from != nil && (from.ops | ops).IsSet(api.OpRename) {
+		rctx.evsink.Event(api.NewRenameEvent(from.name, name, api.OpMask(api.OpRename)))
+		return
+	}
+
+	// Neither side is interested in renames, so report what
+	// each side would have seen individually
+	if from != nil && from.ops.IsSet(api.OpRemove) {
+		rctx.evsink.Event(api.NewEvent(from.name, api.OpMask(api.OpRemove)))
+	}
+	if ops.IsSet(api.OpCreate) {
+		rctx.evsink.Event(api.NewEvent(name, api.OpMask(api.OpCreate)))
+	}
+}
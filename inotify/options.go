@@ -0,0 +1,51 @@
+//go:build linux
+// +build linux
+
+package inotify
+
+import (
+	"time"
+
+	"github.com/lestrrat-go/option"
+)
+
+type Option = option.Interface
+type DriverOption interface {
+	Option
+	driverOption()
+}
+
+type driverOption struct {
+	Option
+}
+
+func (*driverOption) driverOption() {}
+
+type identOverflowRecovery struct{}
+type identPollFallback struct{}
+
+// WithOverflowRecovery specifies that the driver should recover from
+// overflows of the kernel event queue. By default an overflow is
+// reported by sending ErrEventOverflow to the error sink, and the
+// events that were lost are gone for good.
+//
+// When enabled, the driver keeps track of the state of everything it
+// watches. After an overflow, it reports an event with api.OpOverflow,
+// rescans the watched paths, and reports the changes that it finds as
+// regular events. This costs memory proportional to the number of
+// watched files, and a stat(2) call for most events.
+func WithOverflowRecovery(b bool) DriverOption {
+	return &driverOption{option.New(identOverflowRecovery{}, b)}
+}
+
+// WithPollFallback specifies that paths that can not be watched because
+// the limit on inotify watches was reached should be polled at the given
+// interval instead (see the poll package), so that they are still covered.
+// The error is reported to the error sink either way. Paths that are
+// polled stay polled until they are removed, even if watches are freed
+// in the meantime.
+//
+// By default, such paths are not watched at all.
+func WithPollFallback(interval time.Duration) DriverOption {
+	return &driverOption{option.New(identPollFallback{}, interval)}
+}
@@ -0,0 +1,247 @@
+//go:build linux
+// +build linux
+
+package inotify
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/lestrrat-go/fsnotify/api"
+	"golang.org/x/sys/unix"
+)
+
+// anchorFlags are the inotify flags that are required to notice that
+// the next path component towards a pending target was created, or
+// that the anchor itself went away
+const anchorFlags = unix.IN_CREATE | unix.IN_MOVED_TO | unix.IN_DELETE_SELF | unix.IN_MOVE_SELF
+
+// pendingTarget is a target that was added with api.WithPending. While
+// the target does not exist, the nearest existing directory above it
+// (its anchor) is watched for entries being created in it.
+type pendingTarget struct {
+	req    *addRequest
+	anchor string // directory that is watched on behalf of the target, empty if the target itself is watched
+}
+
+// addPending adds a target that does not need to exist yet. Errors that
+// do not prevent the target from being watched are returned separately,
+// so that they can be reported once rctx.mu is released. rctx.mu must
+// be held by the caller.
+func (rctx *runCtx) addPending(req *addRequest) ([]error, error) {
+	pt, ok := rctx.pending[req.path]
+	if ok {
+		merged := *pt.req
+		merged.ops |= req.ops
+		merged.recursive = merged.recursive || req.recursive
+		if req.exclude != nil {
+			merged.exclude = req.exclude
+		}
+		pt.req = &merged
+	} else {
+		pt = &pendingTarget{req: req}
+	}
+
+	// The target is watched right away if it exists. This is not
+	// reported as a CREATE, as it was there before we were asked
+	_, errs, err := rctx.resolve(pt)
+	if err != nil {
+		return errs, err
+	}
+	rctx.pending[req.path] = pt
+	return errs, nil
+}
+
+// resolve brings a pending target in line with the file system: the
+// target itself is watched if it exists, otherwise its anchor is. If
+// the target started being watched, it is returned along with the
+// entries that were found below it. rctx.mu must be held by the caller.
+func (rctx *runCtx) resolve(pt *pendingTarget) ([]string, []error, error) {
+	for {
+		found, errs, err := rctx.watchTarget(pt.req)
+		if err == nil {
+			if err := rctx.releaseAnchor(pt); err != nil {
+				errs = append(errs, err)
+			}
+			return append([]string{pt.req.path}, found...), errs, nil
+		}
+		if !os.IsNotExist(err) && !errors.Is(err, unix.ENOTDIR) {
+			return nil, nil, err
+		}
+
+		anchor := existingParent(pt.req.path)
+		if _, ok := rctx.watches[anchor]; ok && anchor == pt.anchor {
+			return nil, nil, nil
+		}
+		if err := rctx.releaseAnchor(pt); err != nil {
+			errs = append(errs, err)
+		}
+		if err := rctx.addAnchor(anchor); err != nil {
+			if os.IsNotExist(err) {
+				// The anchor was removed before we managed to watch it
+				continue
+			}
+			return nil, errs, err
+		}
+		pt.anchor = anchor
+
+		// The next path component may have been created before the
+		// anchor was watched, so take another look before waiting
+	}
+}
+
+// existingParent returns the nearest directory above path that exists
+func existingParent(path string) string {
+	dir := filepath.Dir(path)
+	for {
+		if fi, err := os.Stat(dir); err == nil && fi.IsDir() {
+			return dir
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return dir
+		}
+		dir = parent
+	}
+}
+
+// addAnchor watches dir for entries being created in it on behalf of
+// a pending target, or a target that follows its name. Anchors are
+// shared between targets, and with regular watches. rctx.mu must be
+// held by the caller.
+func (rctx *runCtx) addAnchor(dir string) error {
+	wd, errno := inotifyAddWatch(rctx.infd, dir, anchorFlags|unix.IN_ONLYDIR|unix.IN_MASK_ADD)
+	if wd == -1 {
+		if errno == unix.ENOSPC {
+			return newLimitError("max_user_watches", len(rctx.paths), errno)
+		}
+		return errno
+	}
+
+	watchEntry := rctx.watches[dir]
+	if watchEntry == nil {
+		watchEntry = &watch{}
+		rctx.watches[dir] = watchEntry
+	}
+	watchEntry.wd = uint32(wd)
+	watchEntry.flags |= anchorFlags
+	watchEntry.anchors++
+	rctx.paths[wd] = dir
+	return nil
+}
+
+// releaseAnchor stops watching the anchor of a pending target, unless
+// it is still needed by other pending targets or regular watches.
+// rctx.mu must be held by the caller.
+func (rctx *runCtx) releaseAnchor(pt *pendingTarget) error {
+	anchor := pt.anchor
+	if anchor == "" {
+		return nil
+	}
+	pt.anchor = ""
+	return rctx.removeAnchor(anchor)
+}
+
+// removeAnchor drops one reference to the anchor dir, and stops watching
+// it if it is no longer needed. rctx.mu must be held by the caller.
+func (rctx *runCtx) removeAnchor(dir string) error {
+	watchEntry, ok := rctx.watches[dir]
+	if !ok {
+		return nil
+	}
+	watchEntry.anchors--
+	if watchEntry.anchors > 0 || watchEntry.ops != 0 {
+		return nil
+	}
+	return rctx.removeWatch(dir)
+}
+
+// updatePending is called for every event that was received by the
+// watch for dir, where name is the path that the event is about. Pending
+// targets that were waiting for name to be created take another look,
+// and pending targets that were removed or moved away go back to waiting.
+// CREATE events are reported for the targets that showed up.
+func (rctx *runCtx) updatePending(dir, name string, rawMask uint32) {
+	rctx.mu.Lock()
+	var targets []*pendingTarget
+	var errs []error
+	for _, pt := range rctx.pending {
+		target := pt.req.path
+		switch {
+		case pt.anchor == dir && rawMask&(unix.IN_DELETE_SELF|unix.IN_MOVE_SELF) != 0:
+			targets = append(targets, pt)
+		case pt.anchor == dir && rawMask&anchorFlags != 0:
+			if name == target || strings.HasPrefix(target, name+string(filepath.Separator)) {
+				targets = append(targets, pt)
+			}
+		case pt.anchor == "" && target == dir && rawMask&(unix.IN_DELETE_SELF|unix.IN_MOVE_SELF) != 0:
+			// After IN_MOVE_SELF, the watch follows the target to
+			// wherever it went. It is of no use to us anymore.
+			if pt.req.recursive {
+				errs = append(errs, rctx.removeTree(dir)...)
+			}
+			if err := rctx.removeWatch(dir); err != nil {
+				errs = append(errs, err)
+			}
+			targets = append(targets, pt)
+		}
+	}
+	created, resolveErrs := rctx.resolveAll(targets)
+	errs = append(errs, resolveErrs...)
+	rctx.mu.Unlock()
+
+	rctx.reportCreated(created, errs)
+}
+
+// retryPending takes another look at all pending targets that are
+// waiting to be created, e.g. after events were lost
+func (rctx *runCtx) retryPending() {
+	rctx.mu.Lock()
+	var targets []*pendingTarget
+	for _, pt := range rctx.pending {
+		if pt.anchor != "" {
+			targets = append(targets, pt)
+		}
+	}
+	created, errs := rctx.resolveAll(targets)
+	rctx.mu.Unlock()
+
+	rctx.reportCreated(created, errs)
+}
+
+// resolveAll resolves the given pending targets, and returns the paths
+// for which CREATE events should be reported. rctx.mu must be held by
+// the caller.
+func (rctx *runCtx) resolveAll(targets []*pendingTarget) ([]string, []error) {
+	// Report the targets in a stable order
+	sort.Slice(targets, func(i, j int) bool {
+		return targets[i].req.path < targets[j].req.path
+	})
+
+	var created []string
+	var errs []error
+	for _, pt := range targets {
+		found, resolveErrs, err := rctx.resolve(pt)
+		errs = append(errs, resolveErrs...)
+		if err != nil {
+			errs = append(errs, newError("add", pt.req.path, err))
+			continue
+		}
+		if pt.req.ops.IsSet(api.OpCreate) {
+			created = append(created, found...)
+		}
+	}
+	return created, errs
+}
+
+func (rctx *runCtx) reportCreated(created []string, errs []error) {
+	for _, path := range created {
+		rctx.evsink.Event(api.NewEvent(path, api.OpMask(api.OpCreate)))
+	}
+	for _, err := range errs {
+		rctx.errsink.Error(err)
+	}
+}
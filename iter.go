@@ -0,0 +1,90 @@
+//go:build go1.23
+
+package fsnotify
+
+import (
+	"context"
+	"iter"
+
+	"github.com/lestrrat-go/fsnotify/api"
+)
+
+// Events returns an iterator over the events and errors reported while
+// watching. Watch is started when the iteration begins, and stopped
+// when the loop is exited. Events are yielded with a nil error, and
+// errors with a nil event:
+//
+//	for ev, err := range w.Events(ctx) {
+//	  if err != nil {
+//	    ...
+//	    continue
+//	  }
+//	  ...
+//	}
+//
+// When Watch returns on its own, e.g. because ctx is done or the driver
+// failed, the error that it returned is yielded, and the iteration ends.
+// The options are passed to Watch, except for WithEventSink and
+// WithErrorSink, which are ignored.
+func (w *Watcher) Events(ctx context.Context, options ...WatchOption) iter.Seq2[api.Event, error] {
+	return func(yield func(api.Event, error) bool) {
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+
+		sink := &iterSink{
+			ctx:    ctx,
+			events: make(chan api.Event),
+			errors: make(chan error),
+		}
+		options = append(options[:len(options):len(options)], WithEventSink(sink), WithErrorSink(sink))
+
+		watchErr := make(chan error, 1)
+		go func() {
+			watchErr <- w.Watch(ctx, options...)
+		}()
+
+		stop := func() {
+			cancel()
+			<-watchErr
+		}
+		for {
+			select {
+			case ev := <-sink.events:
+				if !yield(ev, nil) {
+					stop()
+					return
+				}
+			case err := <-sink.errors:
+				if !yield(nil, err) {
+					stop()
+					return
+				}
+			case err := <-watchErr:
+				yield(nil, err)
+				return
+			}
+		}
+	}
+}
+
+// iterSink hands over events and errors to the iterator, and gives
+// up when the iteration has ended
+type iterSink struct {
+	ctx    context.Context
+	events chan api.Event
+	errors chan error
+}
+
+func (sink *iterSink) Event(ev api.Event) {
+	select {
+	case <-sink.ctx.Done():
+	case sink.events <- ev:
+	}
+}
+
+func (sink *iterSink) Error(err error) {
+	select {
+	case <-sink.ctx.Done():
+	case sink.errors <- err:
+	}
+}
@@ -0,0 +1,78 @@
+//go:build go1.23
+
+package fsnotify_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/lestrrat-go/fsnotify"
+	"github.com/lestrrat-go/fsnotify/api"
+	"github.com/lestrrat-go/fsnotify/fsnotifytest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEvents(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	t.Run("Break", func(t *testing.T) {
+		driver := fsnotifytest.NewDriver()
+		watcher := fsnotify.Create(driver)
+
+		expected := []interface{}{
+			api.NewEvent("/foo", api.OpMask(api.OpCreate)),
+			errors.New(`failure`),
+			api.NewEvent("/foo", api.OpMask(api.OpWrite)),
+		}
+		go func() {
+			for _, v := range expected {
+				switch v := v.(type) {
+				case api.Event:
+					driver.SendEvent(ctx, v)
+				case error:
+					driver.SendError(ctx, v)
+				}
+			}
+		}()
+
+		var got []interface{}
+		for ev, err := range watcher.Events(ctx) {
+			if err != nil {
+				got = append(got, err)
+			} else {
+				got = append(got, ev)
+			}
+			if len(got) == len(expected) {
+				break
+			}
+		}
+		if !assert.Equal(t, expected, got, `events and errors should be yielded in order`) {
+			return
+		}
+		stopped := func(d *fsnotifytest.Driver) bool { return !d.Running() }
+		if !assert.NoError(t, driver.WaitFor(ctx, stopped), `driver should be stopped when the loop is exited`) {
+			return
+		}
+	})
+	t.Run("Driver failure", func(t *testing.T) {
+		driver := fsnotifytest.NewDriver()
+		watcher := fsnotify.Create(driver)
+
+		failure := errors.New(`failure`)
+		go driver.Fail(ctx, failure)
+
+		var errs []error
+		for ev, err := range watcher.Events(ctx) {
+			if !assert.Nil(t, ev, `no events should be yielded`) {
+				return
+			}
+			errs = append(errs, err)
+		}
+		if !assert.Equal(t, []error{failure}, errs, `the error returned by Watch should be yielded`) {
+			return
+		}
+	})
+}
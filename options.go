@@ -1,7 +1,10 @@
 package fsnotify
 
 import (
+	"time"
+
 	"github.com/lestrrat-go/fsnotify/api"
+	"github.com/lestrrat-go/fsnotify/filter"
 	"github.com/lestrrat-go/option"
 )
 
@@ -18,8 +21,29 @@ type watchOption struct {
 
 func (*watchOption) watchOption() {}
 
+type DebounceOption interface {
+	Option
+	debounceOption()
+}
+
+type debounceOption struct {
+	Option
+}
+
+func (*debounceOption) debounceOption() {}
+
 type identErrorSink struct{}
 type identEventSink struct{}
+type identFilter struct{}
+type identCheckpoint struct{}
+type identCheckpointInterval struct{}
+type identRestart struct{}
+type identRestartDelay struct{}
+type identMaxRestartDelay struct{}
+type identRescan struct{}
+type identEventQueueSize struct{}
+type identQuietPeriod struct{}
+type identMaxDelay struct{}
 
 func WithErrorSink(sink api.ErrorSink) WatchOption {
 	return &watchOption{option.New(identErrorSink{}, sink)}
@@ -28,3 +52,130 @@ func WithErrorSink(sink api.ErrorSink) WatchOption {
 func WithEventSink(sink api.EventSink) WatchOption {
 	return &watchOption{option.New(identEventSink{}, sink)}
 }
+
+// WithFilter specifies a filter that decides which events are
+// delivered to the event sink. Directories that are excluded by the
+// filter are also not watched when targets are watched recursively,
+// which saves watch descriptors on large trees.
+func WithFilter(f *filter.Filter) WatchOption {
+	return &watchOption{option.New(identFilter{}, f)}
+}
+
+// WithCheckpoint specifies a file where the state of the watch targets
+// is persisted while Watch is running. When Watch starts, the changes
+// between the state in the file and the current state are reported as
+// regular events (e.g. files that were created while nobody was
+// watching are reported with api.OpCreate). All of these catch-up
+// events are delivered before any live events.
+//
+// The state is saved after the catch-up events are reported,
+// periodically (see WithCheckpointInterval), and when Watch returns.
+// Changes that occur after the last save and are not delivered before
+// the process dies may be reported again on the next run.
+func WithCheckpoint(path string) WatchOption {
+	return &watchOption{option.New(identCheckpoint{}, path)}
+}
+
+// WithCheckpointInterval specifies how often the state is saved when
+// WithCheckpoint is used. Each save scans all watch targets, so the
+// interval should be chosen according to the size of the targets.
+// The default is one minute.
+func WithCheckpointInterval(d time.Duration) WatchOption {
+	return &watchOption{option.New(identCheckpointInterval{}, d)}
+}
+
+// WithRestart specifies that the driver should be restarted when it
+// fails, instead of making Watch return. The error reported by the
+// driver is sent to the error sink, and the driver is restarted after
+// a delay that doubles with each consecutive failure (see
+// WithRestartDelay and WithMaxRestartDelay).
+//
+// Once the driver is running again, the targets are re-added, and an
+// event with api.OpRestart is reported to mark the gap. Changes made
+// while the driver was not running are lost, unless WithRescan or
+// WithCheckpoint is used.
+func WithRestart(b bool) WatchOption {
+	return &watchOption{option.New(identRestart{}, b)}
+}
+
+// WithRestartDelay specifies how long to wait before restarting a
+// failed driver for the first time when WithRestart is used. The
+// default is one second.
+func WithRestartDelay(d time.Duration) WatchOption {
+	return &watchOption{option.New(identRestartDelay{}, d)}
+}
+
+// WithMaxRestartDelay specifies the maximum amount of time to wait
+// before restarting a failed driver when WithRestart is used. The
+// delay goes back to the one specified by WithRestartDelay once the
+// driver has been running for longer than this. The default is one
+// minute.
+func WithMaxRestartDelay(d time.Duration) WatchOption {
+	return &watchOption{option.New(identMaxRestartDelay{}, d)}
+}
+
+// WithRescan specifies that the watch targets should be scanned when
+// the driver fails, and once more after it has been restarted (see
+// WithRestart), so that changes made in between are reported as
+// regular events after the api.OpRestart event, and before any live
+// events. This is implied by WithCheckpoint, which reports the changes
+// since the last saved state instead.
+func WithRescan(b bool) WatchOption {
+	return &watchOption{option.New(identRescan{}, b)}
+}
+
+// WithEventQueueSize specifies how many events are queued until they
+// are read using Next or TryNext, when Watch is called without an
+// event sink. The default is 1024.
+func WithEventQueueSize(n int) WatchOption {
+	return &watchOption{option.New(identEventQueueSize{}, n)}
+}
+
+// WithRecursive specifies that the directory passed to `Add()` should
+// be watched recursively. See api.WithRecursive for details.
+func WithRecursive(b bool) CommandOption {
+	return api.WithRecursive(b)
+}
+
+// WithPending specifies that the target passed to `Add()` does not need
+// to exist yet. See api.WithPending for details.
+func WithPending(b bool) CommandOption {
+	return api.WithPending(b)
+}
+
+// WithFollowName specifies that the watch for the target passed to
+// `Add()` survives the target being replaced. See api.WithFollowName
+// for details.
+func WithFollowName(b bool) CommandOption {
+	return api.WithFollowName(b)
+}
+
+// WithFollowInode specifies that the watch for the file passed to
+// `Add()` follows the file when it is moved. The target is then known
+// under its new name, e.g. when it is passed to `Remove()`, or when it
+// is added again after the driver was restarted. See api.WithFollowInode
+// for details.
+func WithFollowInode(b bool) CommandOption {
+	return api.WithFollowInode(b)
+}
+
+// WithOpMask specifies the set of operations that should be reported
+// for the target passed to `Add()`. See api.WithOpMask for details.
+func WithOpMask(mask api.OpMask) CommandOption {
+	return api.WithOpMask(mask)
+}
+
+// WithQuietPeriod specifies how long a path must go without events
+// before the coalesced event is forwarded by a DebounceSink. The
+// default is 100 milliseconds.
+func WithQuietPeriod(d time.Duration) DebounceOption {
+	return &debounceOption{option.New(identQuietPeriod{}, d)}
+}
+
+// WithMaxDelay specifies the maximum amount of time that a DebounceSink
+// may hold on to events for a path. When the first event for a path is
+// older than this, the coalesced event is forwarded even if events keep
+// coming in. By default there is no limit.
+func WithMaxDelay(d time.Duration) DebounceOption {
+	return &debounceOption{option.New(identMaxDelay{}, d)}
+}
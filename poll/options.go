@@ -0,0 +1,27 @@
+package poll
+
+import (
+	"time"
+
+	"github.com/lestrrat-go/option"
+)
+
+type Option = option.Interface
+type DriverOption interface {
+	Option
+	driverOption()
+}
+
+type driverOption struct {
+	Option
+}
+
+func (*driverOption) driverOption() {}
+
+type identInterval struct{}
+
+// WithInterval specifies the interval at which the watched paths are
+// checked for changes. The default is one second.
+func WithInterval(d time.Duration) DriverOption {
+	return &driverOption{option.New(identInterval{}, d)}
+}
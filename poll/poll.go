@@ -0,0 +1,319 @@
+// Package poll implements a fsnotify driver that periodically checks
+// the watched paths for changes, instead of relying on notifications
+// from the kernel.
+//
+// It is slower and more expensive than the native drivers, but it works
+// on file systems where those never fire, such as NFS, FUSE, and some
+// bind-mounted volumes.
+package poll
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/lestrrat-go/fsnotify/api"
+	"github.com/lestrrat-go/fsnotify/snapshot"
+)
+
+const (
+	cmdAdd = iota + 1
+	cmdRemove
+)
+
+const defaultInterval = time.Second
+
+// Driver is the polling fsnotify driver.
+type Driver struct {
+	interval time.Duration
+	control  chan *api.Command
+	pending  *api.CommandQueue
+}
+
+// addRequest is the payload for cmdAdd
+type addRequest struct {
+	path      string
+	ops       api.OpMask
+	recursive bool
+	exclude   func(string) bool
+	pending   bool
+	follow    bool
+}
+
+// target is a watched path, along with the state of the files
+// that were found during the last scan
+type target struct {
+	ops       api.OpMask
+	recursive bool
+	exclude   func(string) bool
+	follow    bool // report replacements of the target itself as writes
+	snapshot  snapshot.Snapshot
+}
+
+type runCtx struct {
+	evsink  api.EventSink
+	errsink api.ErrorSink
+	targets map[string]*target
+}
+
+func New(options ...DriverOption) *Driver {
+	d := &Driver{
+		interval: defaultInterval,
+		control:  make(chan *api.Command),
+	}
+	for _, option := range options {
+		//nolint:forcetypeassert
+		switch option.Ident() {
+		case identInterval{}:
+			d.interval = option.Value().(time.Duration)
+		}
+	}
+
+	d.pending = api.NewCommandQueue(api.CommandQueueEgressChooseFunc(func(cmd *api.Command) chan *api.Command {
+		switch cmd.Type {
+		case cmdAdd, cmdRemove:
+			return d.control
+		default:
+			panic("unimplemented")
+		}
+	}))
+	return d
+}
+
+// Add adds a new path to be watched by the driver. The path must exist
+// at the time it is added, but it may be removed and re-created later.
+// If api.WithPending(true) is specified, the path does not need to exist
+// yet, and a CREATE event is reported once it shows up.
+//
+// If api.WithFollowName(true) is specified, the target being replaced by
+// another file is reported as a single WRITE event.
+//
+// If the path is a directory, its entries are watched as well. If
+// api.WithRecursive(true) is specified, so are the entries of all
+// directories below it.
+//
+// If api.WithOpMask() is specified, only the requested operations are
+// reported for the path.
+//
+// If api.WithExcludeDir() is specified, the contents of directories that
+// are excluded are not scanned as part of a recursive watch.
+func (driver *Driver) Add(path string, options ...api.CommandOption) error {
+	req := &addRequest{path: path}
+	for _, option := range options {
+		switch ident := option.Ident(); {
+		case api.IsRecursive(ident):
+			//nolint:forcetypeassert
+			req.recursive = option.Value().(bool)
+		case api.IsOpMask(ident):
+			//nolint:forcetypeassert
+			req.ops |= option.Value().(api.OpMask)
+		case api.IsExcludeDir(ident):
+			//nolint:forcetypeassert
+			req.exclude = option.Value().(func(string) bool)
+		case api.IsPending(ident):
+			//nolint:forcetypeassert
+			req.pending = option.Value().(bool)
+		case api.IsFollowName(ident):
+			//nolint:forcetypeassert
+			req.follow = option.Value().(bool)
+		}
+	}
+	if req.ops == 0 {
+		req.ops = api.DefaultOpMask
+	}
+
+	cmd := &api.Command{
+		Type:    cmdAdd,
+		Payload: req,
+	}
+	return driver.pending.SendCmd(cmd, options...)
+}
+
+// Remove removes a path from the driver.
+func (driver *Driver) Remove(path string, options ...api.CommandOption) error {
+	cmd := &api.Command{
+		Type:    cmdRemove,
+		Payload: path,
+	}
+	return driver.pending.SendCmd(cmd, options...)
+}
+
+func (driver *Driver) Run(ctx context.Context, ready chan struct{}, evsink api.EventSink, errsink api.ErrorSink) error {
+	rctx := &runCtx{
+		evsink:  evsink,
+		errsink: errsink,
+		targets: make(map[string]*target),
+	}
+
+	// The commands that the queue holds on to are picked up by the next
+	// Run(), so this one must be done with the queue before it returns
+	drainDone := make(chan struct{})
+	go func() {
+		defer close(drainDone)
+		driver.pending.Drain(ctx)
+	}()
+	defer func() { <-drainDone }()
+
+	ticker := time.NewTicker(driver.interval)
+	defer ticker.Stop()
+
+	close(ready)
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			rctx.poll()
+		case cmd := <-driver.control:
+			var err error
+			switch cmd.Type {
+			case cmdAdd:
+				//nolint:forcetypeassert
+				err = rctx.add(cmd.Payload.(*addRequest))
+			case cmdRemove:
+				//nolint:forcetypeassert
+				err = rctx.remove(cmd.Payload.(string))
+			}
+
+			reply := cmd.Reply
+			if reply == nil {
+				if err != nil {
+					errsink.Error(err)
+				}
+				continue
+			}
+			if err != nil {
+				select {
+				case <-ctx.Done():
+				case reply <- err:
+				}
+			}
+			close(reply)
+		}
+	}
+}
+
+// DriverName is the name of the driver that is reported in api.Error
+const DriverName = "poll"
+
+func newError(op, path string, err error) *api.Error {
+	return api.NewError(DriverName, op, path, err)
+}
+
+// newScanError converts an error from a snapshot into an api.Error
+func newScanError(err error) *api.Error {
+	var path string
+	var pathErr *fs.PathError
+	if errors.As(err, &pathErr) {
+		path = pathErr.Path
+	}
+	return newError("scan", path, err)
+}
+
+func (rctx *runCtx) add(req *addRequest) error {
+	t, ok := rctx.targets[req.path]
+	if ok {
+		t.ops |= req.ops
+		t.recursive = t.recursive || req.recursive
+		t.follow = t.follow || req.follow
+		if req.exclude != nil {
+			t.exclude = req.exclude
+		}
+	} else {
+		// Unlike later scans, the path must exist when it is added,
+		// unless we were asked to wait for it
+		if _, err := os.Lstat(req.path); err != nil && !(req.pending && os.IsNotExist(err)) {
+			return newError("add", req.path, err)
+		}
+		t = &target{ops: req.ops, recursive: req.recursive, exclude: req.exclude, follow: req.follow}
+	}
+
+	// Take the initial snapshot. Changes are reported from here on.
+	snap, errs := snapshot.Scan(req.path, t.scanOptions()...)
+	for _, err := range errs {
+		rctx.errsink.Error(newScanError(err))
+	}
+	t.snapshot = snap
+	rctx.targets[req.path] = t
+	return nil
+}
+
+func (t *target) scanOptions() []snapshot.ScanOption {
+	return []snapshot.ScanOption{
+		snapshot.WithRecursive(t.recursive),
+		snapshot.WithExcludeDir(t.exclude),
+	}
+}
+
+func (rctx *runCtx) remove(path string) error {
+	if _, ok := rctx.targets[path]; !ok {
+		return newError("remove", path, api.ErrNotWatched)
+	}
+	delete(rctx.targets, path)
+	return nil
+}
+
+// poll scans all targets, and reports the changes since the last scan
+func (rctx *runCtx) poll() {
+	paths := make([]string, 0, len(rctx.targets))
+	for path := range rctx.targets {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	for _, path := range paths {
+		t := rctx.targets[path]
+		snap, errs := snapshot.Scan(path, t.scanOptions()...)
+		for _, err := range errs {
+			rctx.errsink.Error(newScanError(err))
+		}
+
+		events := snapshot.Diff(t.snapshot, snap)
+		if t.follow {
+			events = followName(path, events)
+		}
+		for _, ev := range events {
+			rctx.emit(ev, t.ops)
+		}
+		t.snapshot = snap
+	}
+}
+
+// followName merges the REMOVE and CREATE events that are reported when
+// the file at path was replaced by another file into a single WRITE event
+func followName(path string, events []api.Event) []api.Event {
+	var removed, created bool
+	for _, ev := range events {
+		if _, ok := ev.(api.RenameEvent); ok || ev.Name() != path {
+			continue
+		}
+		removed = removed || ev.Mask().IsSet(api.OpRemove)
+		created = created || ev.Mask().IsSet(api.OpCreate)
+	}
+	if !removed || !created {
+		return events
+	}
+
+	merged := make([]api.Event, 0, len(events))
+	for _, ev := range events {
+		if _, ok := ev.(api.RenameEvent); ok || ev.Name() != path {
+			merged = append(merged, ev)
+			continue
+		}
+		if ev.Mask().IsSet(api.OpRemove) {
+			merged = append(merged, api.NewEvent(path, api.OpMask(api.OpWrite)))
+		}
+	}
+	return merged
+}
+
+// emit sends the events that should be reported for ev to a target
+// that is interested in ops to the event sink
+func (rctx *runCtx) emit(ev api.Event, ops api.OpMask) {
+	for _, ev := range snapshot.Filter(ev, ops) {
+		rctx.evsink.Event(ev)
+	}
+}
@@ -0,0 +1,191 @@
+package poll_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/lestrrat-go/fsnotify/api"
+	"github.com/lestrrat-go/fsnotify/drivertest"
+	"github.com/lestrrat-go/fsnotify/poll"
+	"github.com/stretchr/testify/assert"
+)
+
+// Sanity
+var _ api.Driver = &poll.Driver{}
+
+func TestConformance(t *testing.T) {
+	drivertest.Run(t, func(_ *testing.T) api.Driver {
+		return poll.New(poll.WithInterval(10 * time.Millisecond))
+	})
+}
+
+type chanEventSink chan api.Event
+
+func (sink chanEventSink) Event(ev api.Event) {
+	sink <- ev
+}
+
+func TestDriver(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fsnotify-poll-test-*")
+	if !assert.NoError(t, err, `ioutil.TempDir should succeed`) {
+		return
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	evCh := make(chan api.Event, 128)
+	errCh := make(chan error, 128)
+	ready := make(chan struct{})
+
+	driver := poll.New(poll.WithInterval(10 * time.Millisecond))
+	go driver.Run(ctx, ready, chanEventSink(evCh), api.ChanErrSink(errCh))
+	<-ready
+
+	if !assert.NoError(t, driver.Add(dir, api.WithRecursive(true), api.WithAck(true)), `driver.Add should succeed`) {
+		return
+	}
+	if !assert.Error(t, driver.Add(filepath.Join(dir, "nonexistent"), api.WithAck(true)), `driver.Add on a nonexistent path should fail`) {
+		return
+	}
+
+	name := filepath.Join(dir, "sub", "file")
+	renamed := filepath.Join(dir, "renamed")
+	testcases := []struct {
+		Name   string
+		Action func() error
+		Path   string
+		Op     api.Op
+	}{
+		{
+			Name:   "Create",
+			Action: func() error { return os.Mkdir(filepath.Dir(name), 0755) },
+			Path:   filepath.Dir(name),
+			Op:     api.OpCreate,
+		},
+		{
+			Name:   "Create in sub directory",
+			Action: func() error { return ioutil.WriteFile(name, nil, 0644) },
+			Path:   name,
+			Op:     api.OpCreate,
+		},
+		{
+			Name:   "Write",
+			Action: func() error { return ioutil.WriteFile(name, []byte(`Hello, World!`), 0644) },
+			Path:   name,
+			Op:     api.OpWrite,
+		},
+		{
+			Name:   "Chmod",
+			Action: func() error { return os.Chmod(name, 0600) },
+			Path:   name,
+			Op:     api.OpChmod,
+		},
+		{
+			Name:   "Rename",
+			Action: func() error { return os.Rename(name, renamed) },
+			Path:   renamed,
+			Op:     api.OpRename,
+		},
+		{
+			Name:   "Remove",
+			Action: func() error { return os.Remove(renamed) },
+			Path:   renamed,
+			Op:     api.OpRemove,
+		},
+	}
+
+	for _, tc := range testcases {
+		tc := tc
+		t.Run(tc.Name, func(t *testing.T) {
+			if !assert.NoError(t, tc.Action(), `action should succeed`) {
+				return
+			}
+
+			select {
+			case ev := <-evCh:
+				if !assert.Equal(t, tc.Path, ev.Name(), `event name should match`) {
+					return
+				}
+				if !assert.Equal(t, api.OpMask(tc.Op), ev.Mask(), `event mask should match`) {
+					return
+				}
+				if tc.Op == api.OpRename {
+					rev, ok := ev.(api.RenameEvent)
+					if !assert.True(t, ok, `event should be an api.RenameEvent`) {
+						return
+					}
+					if !assert.Equal(t, name, rev.OldName(), `old name should match`) {
+						return
+					}
+				}
+			case err := <-errCh:
+				assert.NoError(t, err, `there should be no errors`)
+			case <-time.After(5 * time.Second):
+				assert.Fail(t, `timed out waiting for event`)
+			}
+		})
+	}
+
+	t.Run("Remove", func(t *testing.T) {
+		if !assert.NoError(t, driver.Remove(dir, api.WithAck(true)), `driver.Remove should succeed`) {
+			return
+		}
+		if !assert.Error(t, driver.Remove(dir, api.WithAck(true)), `driver.Remove on a removed path should fail`) {
+			return
+		}
+	})
+	t.Run("Pending", func(t *testing.T) {
+		later := filepath.Join(dir, "later")
+		if !assert.NoError(t, driver.Add(later, api.WithPending(true), api.WithAck(true)), `driver.Add on a nonexistent path should succeed with api.WithPending`) {
+			return
+		}
+		if !assert.NoError(t, ioutil.WriteFile(later, nil, 0644), `ioutil.WriteFile should succeed`) {
+			return
+		}
+
+		select {
+		case ev := <-evCh:
+			if !assert.Equal(t, api.NewEvent(later, api.OpMask(api.OpCreate)), ev, `a CREATE event should be reported`) {
+				return
+			}
+		case err := <-errCh:
+			assert.NoError(t, err, `there should be no errors`)
+		case <-time.After(5 * time.Second):
+			assert.Fail(t, `timed out waiting for event`)
+		}
+	})
+	t.Run("Follow name", func(t *testing.T) {
+		followed := filepath.Join(dir, "followed")
+		if !assert.NoError(t, ioutil.WriteFile(followed, []byte(`v1`), 0644), `ioutil.WriteFile should succeed`) {
+			return
+		}
+		if !assert.NoError(t, driver.Add(followed, api.WithFollowName(true), api.WithAck(true)), `driver.Add should succeed`) {
+			return
+		}
+
+		tmp := filepath.Join(dir, ".followed.tmp")
+		if !assert.NoError(t, ioutil.WriteFile(tmp, []byte(`v2`), 0644), `ioutil.WriteFile should succeed`) {
+			return
+		}
+		if !assert.NoError(t, os.Rename(tmp, followed), `os.Rename should succeed`) {
+			return
+		}
+
+		select {
+		case ev := <-evCh:
+			if !assert.Equal(t, api.NewEvent(followed, api.OpMask(api.OpWrite)), ev, `replacing the file should be reported as a WRITE event`) {
+				return
+			}
+		case err := <-errCh:
+			assert.NoError(t, err, `there should be no errors`)
+		case <-time.After(5 * time.Second):
+			assert.Fail(t, `timed out waiting for event`)
+		}
+	})
+}
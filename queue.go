@@ -0,0 +1,132 @@
+package fsnotify
+
+import (
+	"context"
+	"sync"
+
+	"github.com/lestrrat-go/fsnotify/api"
+)
+
+const defaultEventQueueSize = 1024
+
+// eventQueue is the event sink that is used by Watch when no other
+// sink is specified. The events are read using Watcher.Next and
+// Watcher.TryNext.
+type eventQueue struct {
+	mu         sync.Mutex
+	events     []api.Event
+	size       int
+	overflowed bool  // events were dropped since the last one queued
+	err        error // set when Watch returns
+	changed    chan struct{}
+}
+
+func newEventQueue() *eventQueue {
+	return &eventQueue{
+		size:    defaultEventQueueSize,
+		changed: make(chan struct{}),
+	}
+}
+
+// must be called while holding q.mu
+func (q *eventQueue) signal() {
+	close(q.changed)
+	q.changed = make(chan struct{})
+}
+
+// start prepares the queue for a new call to Watch. Events that were
+// not read yet are kept.
+func (q *eventQueue) start(size int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.size = size
+	q.err = nil
+}
+
+// stop records the error returned by Watch, so that it can be
+// returned once the remaining events have been read
+func (q *eventQueue) stop(err error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.err = err
+	q.signal()
+}
+
+// Event queues ev. When the queue is full, the event is dropped, and
+// an event with api.OpOverflow is queued in its place once there is
+// room again, or once the queue has been drained.
+func (q *eventQueue) Event(ev api.Event) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if len(q.events) >= q.size {
+		q.overflowed = true
+		return
+	}
+	if q.overflowed {
+		q.events = append(q.events, overflowEvent())
+		q.overflowed = false
+	}
+	q.events = append(q.events, ev)
+	q.signal()
+}
+
+// pop returns the next event. q.mu must be held by the caller.
+func (q *eventQueue) pop() (api.Event, bool) {
+	if len(q.events) == 0 {
+		if !q.overflowed {
+			return nil, false
+		}
+		q.overflowed = false
+		return overflowEvent(), true
+	}
+	ev := q.events[0]
+	q.events[0] = nil
+	q.events = q.events[1:]
+	return ev, true
+}
+
+func overflowEvent() api.Event {
+	return api.NewEvent("", api.OpMask(api.OpOverflow))
+}
+
+// Next returns the next event that was reported while Watch was
+// running without an event sink (see WithEventSink). It blocks until an
+// event is available, or until ctx is done, in which case ctx.Err() is
+// returned. Once Watch has returned and all remaining events have been
+// read, the error returned by Watch is returned.
+//
+// Events are queued until they are read (see WithEventQueueSize). When
+// the queue is full, further events are dropped, and an event with
+// api.OpOverflow is returned in their place.
+func (w *Watcher) Next(ctx context.Context) (api.Event, error) {
+	q := w.queue
+	for {
+		q.mu.Lock()
+		ev, ok := q.pop()
+		err := q.err
+		changed := q.changed
+		q.mu.Unlock()
+
+		if ok {
+			return ev, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-changed:
+		}
+	}
+}
+
+// TryNext returns the next event in the same way as Next, but does not
+// block. If no event is available, false is returned.
+func (w *Watcher) TryNext() (api.Event, bool) {
+	w.queue.mu.Lock()
+	defer w.queue.mu.Unlock()
+	return w.queue.pop()
+}
@@ -0,0 +1,107 @@
+package fsnotify_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/lestrrat-go/fsnotify"
+	"github.com/lestrrat-go/fsnotify/api"
+	"github.com/lestrrat-go/fsnotify/fsnotifytest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNext(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	driver := fsnotifytest.NewDriver()
+	watcher := fsnotify.Create(driver)
+
+	watchCtx, watchCancel := context.WithCancel(ctx)
+	defer watchCancel()
+	go watcher.Watch(watchCtx, fsnotify.WithEventQueueSize(2))
+	if !assert.NoError(t, driver.WaitRunning(ctx), `driver should be running`) {
+		return
+	}
+
+	events := []api.Event{
+		api.NewEvent("/foo", api.OpMask(api.OpCreate)),
+		api.NewEvent("/foo", api.OpMask(api.OpWrite)),
+		api.NewEvent("/foo", api.OpMask(api.OpRemove)),
+		api.NewEvent("/bar", api.OpMask(api.OpCreate)),
+	}
+
+	t.Run("Empty", func(t *testing.T) {
+		_, ok := watcher.TryNext()
+		if !assert.False(t, ok, `TryNext should not return an event`) {
+			return
+		}
+
+		timeoutCtx, timeoutCancel := context.WithTimeout(ctx, 100*time.Millisecond)
+		defer timeoutCancel()
+		_, err := watcher.Next(timeoutCtx)
+		if !assert.ErrorIs(t, err, context.DeadlineExceeded, `Next should wait for an event`) {
+			return
+		}
+	})
+	t.Run("Next", func(t *testing.T) {
+		if !assert.NoError(t, driver.SendEvent(ctx, events[0]), `driver.SendEvent should succeed`) {
+			return
+		}
+		ev, err := watcher.Next(ctx)
+		if !assert.NoError(t, err, `Next should succeed`) {
+			return
+		}
+		if !assert.Equal(t, events[0], ev, `event should match`) {
+			return
+		}
+	})
+	t.Run("Overflow", func(t *testing.T) {
+		// The queue only holds two events, so the third one is lost
+		for _, ev := range events[1:] {
+			if !assert.NoError(t, driver.SendEvent(ctx, ev), `driver.SendEvent should succeed`) {
+				return
+			}
+		}
+
+		expected := []api.Event{
+			events[1],
+			events[2],
+			api.NewEvent("", api.OpMask(api.OpOverflow)),
+		}
+		for i, expected := range expected {
+			ev, ok := watcher.TryNext()
+			if !assert.True(t, ok, `TryNext should return events[%d]`, i) {
+				return
+			}
+			if !assert.Equal(t, expected, ev, `events[%d] should match`, i) {
+				return
+			}
+		}
+		_, ok := watcher.TryNext()
+		if !assert.False(t, ok, `TryNext should not return more events`) {
+			return
+		}
+	})
+	t.Run("Watch returns", func(t *testing.T) {
+		if !assert.NoError(t, driver.SendEvent(ctx, events[3]), `driver.SendEvent should succeed`) {
+			return
+		}
+		watchCancel()
+
+		// Events that were queued are still returned
+		ev, err := watcher.Next(ctx)
+		if !assert.NoError(t, err, `Next should succeed`) {
+			return
+		}
+		if !assert.Equal(t, events[3], ev, `event should match`) {
+			return
+		}
+
+		_, err = watcher.Next(ctx)
+		if !assert.ErrorIs(t, err, context.Canceled, `Next should return the error returned by Watch`) {
+			return
+		}
+	})
+}
@@ -0,0 +1,116 @@
+package replay
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/lestrrat-go/fsnotify/api"
+)
+
+// DriverName is the name of the driver that is reported in api.Error
+const DriverName = "replay"
+
+// Driver is an api.Driver that replays a recording made by a Recorder.
+// Watch targets are accepted but ignored: the recording is replayed
+// as is, regardless of the targets that are being watched.
+type Driver struct {
+	src   io.Reader
+	speed float64
+}
+
+// New creates a new Driver that replays the recording read from r.
+// The recording is read as it is replayed, and can only be replayed once.
+func New(r io.Reader, options ...DriverOption) *Driver {
+	d := &Driver{
+		src:   r,
+		speed: 1,
+	}
+	for _, option := range options {
+		//nolint:gocritic
+		switch option.Ident() {
+		case identSpeed{}:
+			//nolint:forcetypeassert
+			d.speed = option.Value().(float64)
+		}
+	}
+	return d
+}
+
+func (d *Driver) Add(_ string, _ ...api.CommandOption) error {
+	return nil
+}
+
+func (d *Driver) Remove(_ string, _ ...api.CommandOption) error {
+	return nil
+}
+
+// Run replays the recording. Events and errors are reported with the
+// same delays between them as when they were recorded, adjusted by
+// the speed (see WithSpeed). Once the whole recording has been
+// replayed, Run waits until ctx is done. Errors that occur while
+// reading the recording end the replay, and are returned by Run.
+func (d *Driver) Run(ctx context.Context, ready chan struct{}, evsink api.EventSink, errsink api.ErrorSink) error {
+	close(ready)
+	if err := d.replay(ctx, evsink, errsink); err != nil {
+		e := api.NewError(DriverName, "read", "", err)
+		e.Fatal = true
+		return e
+	}
+	<-ctx.Done()
+	return nil
+}
+
+func (d *Driver) replay(ctx context.Context, evsink api.EventSink, errsink api.ErrorSink) error {
+	scanner := bufio.NewScanner(d.src)
+	scanner.Buffer(nil, 1024*1024)
+
+	var prev time.Time
+	for lineno := 1; scanner.Scan(); lineno++ {
+		select {
+		case <-ctx.Done():
+			return nil
+		default:
+		}
+
+		var rec record
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			return fmt.Errorf(`failed to parse line %d of recording: %w`, lineno, err)
+		}
+
+		if !prev.IsZero() && d.speed > 0 {
+			delay := time.Duration(float64(rec.Time.Sub(prev)) / d.speed)
+			if delay > 0 {
+				timer := time.NewTimer(delay)
+				select {
+				case <-ctx.Done():
+					timer.Stop()
+					return nil
+				case <-timer.C:
+				}
+			}
+		}
+		prev = rec.Time
+
+		switch rec.Type {
+		case recordEvent:
+			if rec.OldName != "" {
+				evsink.Event(api.NewRenameEvent(rec.OldName, rec.Name, rec.Mask))
+			} else {
+				evsink.Event(api.NewEvent(rec.Name, rec.Mask))
+			}
+		case recordError:
+			errsink.Error(errors.New(rec.Error))
+		default:
+			return fmt.Errorf(`unknown record type %q on line %d of recording`, rec.Type, lineno)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf(`failed to read recording: %w`, err)
+	}
+	return nil
+}
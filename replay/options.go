@@ -0,0 +1,54 @@
+package replay
+
+import (
+	"github.com/lestrrat-go/fsnotify/api"
+	"github.com/lestrrat-go/option"
+)
+
+type Option = option.Interface
+type RecorderOption interface {
+	Option
+	recorderOption()
+}
+
+type recorderOption struct {
+	Option
+}
+
+func (*recorderOption) recorderOption() {}
+
+type DriverOption interface {
+	Option
+	driverOption()
+}
+
+type driverOption struct {
+	Option
+}
+
+func (*driverOption) driverOption() {}
+
+type identEventSink struct{}
+type identErrorSink struct{}
+type identSpeed struct{}
+
+// WithEventSink specifies a sink that events are forwarded to after
+// they have been recorded. This allows a Recorder to be plugged into
+// a running application without changing what it receives.
+func WithEventSink(sink api.EventSink) RecorderOption {
+	return &recorderOption{option.New(identEventSink{}, sink)}
+}
+
+// WithErrorSink specifies a sink that errors are forwarded to after
+// they have been recorded.
+func WithErrorSink(sink api.ErrorSink) RecorderOption {
+	return &recorderOption{option.New(identErrorSink{}, sink)}
+}
+
+// WithSpeed specifies how fast the recording is replayed, relative to
+// the original timing. For example, 2 replays the recording twice as
+// fast as it was recorded. A value of zero or less replays the
+// recording without any delays. The default is 1.
+func WithSpeed(speed float64) DriverOption {
+	return &driverOption{option.New(identSpeed{}, speed)}
+}
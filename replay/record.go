@@ -0,0 +1,105 @@
+// Package replay records the events and errors that are reported by
+// a Watcher, and replays them later through a driver. This allows an
+// event sequence that was observed in production to be reproduced in
+// tests.
+//
+// Recordings are line oriented: each line is a JSON object describing
+// a single event or error, along with the time it was received.
+package replay
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/lestrrat-go/fsnotify/api"
+)
+
+const (
+	recordEvent = "event"
+	recordError = "error"
+)
+
+// record is a single line in a recording
+type record struct {
+	Time    time.Time  `json:"time"`
+	Type    string     `json:"type"`
+	Name    string     `json:"name,omitempty"`
+	OldName string     `json:"old_name,omitempty"`
+	Mask    api.OpMask `json:"mask,omitempty"`
+	Error   string     `json:"error,omitempty"`
+}
+
+// Recorder is an api.EventSink and api.ErrorSink that writes everything
+// it receives to an io.Writer, so that it can be replayed with a Driver.
+type Recorder struct {
+	mu      sync.Mutex
+	enc     *json.Encoder
+	err     error
+	evSink  api.EventSink
+	errSink api.ErrorSink
+}
+
+// NewRecorder creates a new Recorder that writes to w. Writes are not
+// buffered, so w should be buffered if it is expensive to write to.
+func NewRecorder(w io.Writer, options ...RecorderOption) *Recorder {
+	r := &Recorder{
+		enc:     json.NewEncoder(w),
+		evSink:  api.NilSink{},
+		errSink: api.NilSink{},
+	}
+	for _, option := range options {
+		switch option.Ident() {
+		case identEventSink{}:
+			//nolint:forcetypeassert
+			r.evSink = option.Value().(api.EventSink)
+		case identErrorSink{}:
+			//nolint:forcetypeassert
+			r.errSink = option.Value().(api.ErrorSink)
+		}
+	}
+	return r
+}
+
+func (r *Recorder) write(rec *record) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err != nil {
+		return
+	}
+	r.err = r.enc.Encode(rec)
+}
+
+// Event records the event, and forwards it
+func (r *Recorder) Event(ev api.Event) {
+	rec := &record{
+		Time: time.Now(),
+		Type: recordEvent,
+		Name: ev.Name(),
+		Mask: ev.Mask(),
+	}
+	if rev, ok := ev.(api.RenameEvent); ok {
+		rec.OldName = rev.OldName()
+	}
+	r.write(rec)
+	r.evSink.Event(ev)
+}
+
+// Error records the error, and forwards it
+func (r *Recorder) Error(err error) {
+	r.write(&record{
+		Time:  time.Now(),
+		Type:  recordError,
+		Error: err.Error(),
+	})
+	r.errSink.Error(err)
+}
+
+// Err returns the first error that occurred while writing. Once
+// writing fails, nothing else is recorded.
+func (r *Recorder) Err() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.err
+}
@@ -0,0 +1,107 @@
+package replay_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lestrrat-go/fsnotify"
+	"github.com/lestrrat-go/fsnotify/api"
+	"github.com/lestrrat-go/fsnotify/replay"
+	"github.com/stretchr/testify/assert"
+)
+
+// Sanity
+var _ api.Driver = &replay.Driver{}
+
+func TestRoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	eventCh := make(chan api.Event, 3)
+	rec := replay.NewRecorder(&buf, replay.WithEventSink(fsnotify.ChannelEventSink(eventCh)))
+
+	events := []api.Event{
+		api.NewEvent("/foo", api.OpMask(api.OpCreate)),
+		api.NewEvent("/foo", api.OpMask(api.OpWrite|api.OpChmod)),
+		api.NewRenameEvent("/foo", "/bar", api.OpMask(api.OpRename)),
+	}
+	rec.Event(events[0])
+	rec.Event(events[1])
+	rec.Error(errors.New(`boom`))
+	rec.Event(events[2])
+	if !assert.NoError(t, rec.Err(), `recording should succeed`) {
+		return
+	}
+	if !assert.Len(t, eventCh, 3, `events should be forwarded`) {
+		return
+	}
+	if !assert.Equal(t, 4, strings.Count(buf.String(), "\n"), `each record should be a line`) {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	watcher := fsnotify.Create(replay.New(&buf, replay.WithSpeed(0)))
+	replayedCh := make(chan api.Event)
+	errCh := make(chan error)
+	go watcher.Watch(ctx,
+		fsnotify.WithEventSink(fsnotify.ChannelEventSink(replayedCh)),
+		fsnotify.WithErrorSink(fsnotify.ChannelErrorSink(errCh)),
+	)
+
+	for i, expected := range events {
+		select {
+		case ev := <-replayedCh:
+			if !assert.Equal(t, expected, ev, `events[%d] should match`, i) {
+				return
+			}
+		case <-ctx.Done():
+			assert.Fail(t, `timed out waiting for event`)
+			return
+		}
+
+		if i == 1 {
+			select {
+			case err := <-errCh:
+				if !assert.EqualError(t, err, `boom`, `error should match`) {
+					return
+				}
+			case <-ctx.Done():
+				assert.Fail(t, `timed out waiting for error`)
+				return
+			}
+		}
+	}
+}
+
+func TestSpeed(t *testing.T) {
+	recording := `{"time":"2026-01-01T00:00:00Z","type":"event","name":"/foo","mask":1}
+{"time":"2026-01-01T00:00:01Z","type":"event","name":"/foo","mask":2}
+`
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	eventCh := make(chan api.Event)
+	driver := replay.New(strings.NewReader(recording), replay.WithSpeed(10))
+	go driver.Run(ctx, make(chan struct{}), fsnotify.ChannelEventSink(eventCh), api.NilSink{})
+
+	var received []time.Time
+	for len(received) < 2 {
+		select {
+		case <-eventCh:
+			received = append(received, time.Now())
+		case <-ctx.Done():
+			assert.Fail(t, `timed out waiting for event`)
+			return
+		}
+	}
+
+	// One second, ten times as fast
+	elapsed := received[1].Sub(received[0])
+	if !assert.True(t, elapsed >= 90*time.Millisecond && elapsed < 900*time.Millisecond, `events should be 100ms apart (got %s)`, elapsed) {
+		return
+	}
+}
@@ -0,0 +1,12 @@
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd && !solaris
+// +build !darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd,!solaris
+
+package snapshot
+
+import "os"
+
+// fileID is not available on this platform, so renames are
+// reported as a removal followed by a creation
+func fileID(_ os.FileInfo) (uint64, uint64) {
+	return 0, 0
+}
@@ -0,0 +1,18 @@
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd || solaris
+// +build darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package snapshot
+
+import (
+	"os"
+	"syscall"
+)
+
+func fileID(fi os.FileInfo) (uint64, uint64) {
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, 0
+	}
+	//nolint:unconvert
+	return uint64(st.Dev), uint64(st.Ino)
+}
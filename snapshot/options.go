@@ -0,0 +1,46 @@
+package snapshot
+
+import (
+	"hash"
+
+	"github.com/lestrrat-go/option"
+)
+
+type Option = option.Interface
+type ScanOption interface {
+	Option
+	scanOption()
+}
+
+type scanOption struct {
+	Option
+}
+
+func (*scanOption) scanOption() {}
+
+type identRecursive struct{}
+type identExcludeDir struct{}
+type identHash struct{}
+
+// WithRecursive specifies that the entries of all directories below
+// the scanned path should be captured, as opposed to only those of the
+// scanned path itself.
+func WithRecursive(b bool) ScanOption {
+	return &scanOption{option.New(identRecursive{}, b)}
+}
+
+// WithExcludeDir specifies a function that is consulted before the
+// contents of a directory are captured during a recursive scan.
+// Directories for which fn returns true are captured, but their
+// contents are not.
+func WithExcludeDir(fn func(string) bool) ScanOption {
+	return &scanOption{option.New(identExcludeDir{}, fn)}
+}
+
+// WithHash specifies that the contents of regular files should be
+// hashed using hash functions created by fn (e.g. sha256.New).
+// Hashing requires reading every file, so it is much more expensive
+// than a regular scan. See Diff for how hashes are used.
+func WithHash(fn func() hash.Hash) ScanOption {
+	return &scanOption{option.New(identHash{}, fn)}
+}
@@ -0,0 +1,389 @@
+// Package snapshot captures the state of files and directories, so
+// that changes can be detected by comparing two captures. It is used
+// by drivers that need to find out what changed without the help of
+// the kernel, e.g. when polling, or after events have been lost.
+//
+// It can also be used to find out what changed while nobody was
+// watching, e.g. while a service was not running:
+//
+//	old, _ := snapshot.Scan(dir, snapshot.WithRecursive(true))
+//	// ... later
+//	cur, _ := snapshot.Scan(dir, snapshot.WithRecursive(true))
+//	for _, ev := range snapshot.Diff(old, cur) {
+//		...
+//	}
+//
+// The events are the same as those that a driver would have reported,
+// so they can be processed along with live events.
+package snapshot
+
+import (
+	"bytes"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/lestrrat-go/fsnotify/api"
+)
+
+// Entry is the state of a single file at the time it was captured
+type Entry struct {
+	// Dev and Ino identify the file on platforms that support it.
+	// They are used to detect renames.
+	Dev     uint64      `json:"dev,omitempty"`
+	Ino     uint64      `json:"ino,omitempty"`
+	Size    int64       `json:"size"`
+	Mode    os.FileMode `json:"mode"`
+	ModTime time.Time   `json:"mtime"`
+	// Hash is the hash of the contents of the file. It is only
+	// available for regular files, when WithHash() was specified.
+	Hash []byte `json:"hash,omitempty"`
+}
+
+// hasID returns true if the entry carries a usable file identifier
+func (e *Entry) hasID() bool {
+	return e.Dev != 0 || e.Ino != 0
+}
+
+func (e *Entry) sameFile(other *Entry) bool {
+	return e.Dev == other.Dev && e.Ino == other.Ino
+}
+
+// entryID identifies the file behind an entry, see hasID
+type entryID struct {
+	dev, ino uint64
+}
+
+func (e *Entry) id() entryID {
+	return entryID{dev: e.Dev, ino: e.Ino}
+}
+
+// Snapshot maps file names to their state
+type Snapshot map[string]*Entry
+
+// scanner holds the options for a single scan
+type scanner struct {
+	recursive bool
+	exclude   func(string) bool
+	hash      func() hash.Hash
+}
+
+func newScanner(options []ScanOption) *scanner {
+	var s scanner
+	for _, option := range options {
+		switch option.Ident() {
+		case identRecursive{}:
+			//nolint:forcetypeassert
+			s.recursive = option.Value().(bool)
+		case identExcludeDir{}:
+			//nolint:forcetypeassert
+			s.exclude = option.Value().(func(string) bool)
+		case identHash{}:
+			//nolint:forcetypeassert
+			s.hash = option.Value().(func() hash.Hash)
+		}
+	}
+	return &s
+}
+
+// descend returns true if the contents of dir should be captured
+func (s *scanner) descend(dir string) bool {
+	return s.recursive && (s.exclude == nil || !s.exclude(dir))
+}
+
+func (s *scanner) newEntry(name string, fi os.FileInfo) (*Entry, error) {
+	dev, ino := fileID(fi)
+	e := &Entry{
+		Dev:     dev,
+		Ino:     ino,
+		Size:    fi.Size(),
+		Mode:    fi.Mode(),
+		ModTime: fi.ModTime(),
+	}
+	if s.hash != nil && fi.Mode().IsRegular() {
+		sum, err := s.sum(name)
+		if err != nil {
+			return nil, err
+		}
+		e.Hash = sum
+	}
+	return e, nil
+}
+
+func (s *scanner) sum(name string) ([]byte, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	h := s.hash()
+	if _, err := io.Copy(h, f); err != nil {
+		return nil, fmt.Errorf(`failed to hash %q: %w`, name, err)
+	}
+	return h.Sum(nil), nil
+}
+
+// Scan captures the state of path. If path is a directory, its
+// entries are captured as well, and if WithRecursive(true) is
+// specified, so are the entries of all directories below it.
+//
+// A path that does not exist results in an empty snapshot. Errors
+// for individual entries do not stop the scan, and are returned
+// along with the (partial) snapshot.
+func Scan(path string, options ...ScanOption) (Snapshot, []error) {
+	snap := make(Snapshot)
+	errs := snap.Add(path, options...)
+	return snap, errs
+}
+
+// Add captures the state of path into an existing snapshot, in the
+// same way as Scan. This allows a single snapshot to cover multiple
+// paths.
+func (snap Snapshot) Add(path string, options ...ScanOption) []error {
+	s := newScanner(options)
+	fi, err := os.Lstat(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return []error{err}
+	}
+	e, err := s.newEntry(path, fi)
+	if err != nil {
+		return []error{err}
+	}
+	snap[path] = e
+
+	if fi.IsDir() {
+		return s.scanDir(snap, path)
+	}
+	return nil
+}
+
+// Update refreshes the state of a single path in the snapshot, which
+// is cheaper than taking a new snapshot when the caller knows which
+// path changed. If the path is gone, it is removed from the snapshot
+// along with everything below it. If the path is a directory that was
+// not known before and WithRecursive(true) is specified, its contents
+// are captured as well.
+func (snap Snapshot) Update(path string, options ...ScanOption) []error {
+	s := newScanner(options)
+	old := snap[path]
+	fi, err := os.Lstat(path)
+	if err != nil && !os.IsNotExist(err) {
+		return []error{err}
+	}
+
+	var cur *Entry
+	if err == nil {
+		cur, err = s.newEntry(path, fi)
+		if err != nil && !os.IsNotExist(err) {
+			return []error{err}
+		}
+	}
+
+	// Anything below a directory that has been replaced or is gone
+	// is no longer valid
+	replaced := old != nil && (cur == nil || (old.hasID() && !old.sameFile(cur)))
+	if replaced && old.Mode.IsDir() {
+		snap.removeTree(path)
+	}
+
+	if cur == nil {
+		delete(snap, path)
+		return nil
+	}
+	snap[path] = cur
+
+	if fi.IsDir() && (old == nil || replaced) && s.descend(path) {
+		return s.scanDir(snap, path)
+	}
+	return nil
+}
+
+func (snap Snapshot) removeTree(dir string) {
+	prefix := dir + string(filepath.Separator)
+	for name := range snap {
+		if strings.HasPrefix(name, prefix) {
+			delete(snap, name)
+		}
+	}
+}
+
+func (s *scanner) scanDir(snap Snapshot, dir string) []error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		// The directory may have been removed since we looked at it
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return []error{fmt.Errorf(`failed to read directory %q: %w`, dir, err)}
+	}
+
+	var errs []error
+	for _, de := range entries {
+		name := filepath.Join(dir, de.Name())
+		fi, err := de.Info()
+		if err != nil {
+			if !os.IsNotExist(err) {
+				errs = append(errs, err)
+			}
+			continue
+		}
+		e, err := s.newEntry(name, fi)
+		if err != nil {
+			if !os.IsNotExist(err) {
+				errs = append(errs, err)
+			}
+			continue
+		}
+		snap[name] = e
+
+		if fi.IsDir() && s.descend(name) {
+			errs = append(errs, s.scanDir(snap, name)...)
+		}
+	}
+	return errs
+}
+
+// modified returns true if the contents of the file have changed
+func (e *Entry) modified(cur *Entry) bool {
+	if cur.Mode.IsDir() {
+		return false
+	}
+	if e.Hash != nil && cur.Hash != nil {
+		return e.Size != cur.Size || !bytes.Equal(e.Hash, cur.Hash)
+	}
+	return e.Size != cur.Size || !e.ModTime.Equal(cur.ModTime)
+}
+
+// Diff compares two snapshots of the same target, and returns the
+// events that describe the changes between them, sorted by name.
+// The events use the same operations as the drivers: files that
+// appeared are reported with api.OpCreate, files that disappeared
+// with api.OpRemove, files whose contents changed with api.OpWrite,
+// and files whose mode changed with api.OpChmod. Use Filter to
+// restrict the events to the operations that a watch is interested in.
+//
+// Files whose contents were hashed in both snapshots are considered
+// modified only if their hashes differ, so that e.g. touching a file
+// is not reported. Otherwise, a change in size or modification time
+// is reported as a modification.
+//
+// Files that disappeared and reappeared under a different name with
+// the same identifier (device and inode) are reported as a single
+// api.RenameEvent.
+func Diff(old, cur Snapshot) []api.Event {
+	var events []api.Event
+	var removed, created []string
+	for name, oldEntry := range old {
+		curEntry, ok := cur[name]
+		if !ok {
+			removed = append(removed, name)
+			continue
+		}
+
+		// The name now points to a different file
+		if oldEntry.hasID() && curEntry.hasID() && !oldEntry.sameFile(curEntry) {
+			removed = append(removed, name)
+			created = append(created, name)
+			continue
+		}
+
+		var mask api.OpMask
+		if oldEntry.modified(curEntry) {
+			mask.Set(api.OpWrite)
+		}
+		if oldEntry.Mode != curEntry.Mode {
+			mask.Set(api.OpChmod)
+		}
+		if mask != 0 {
+			events = append(events, api.NewEvent(name, mask))
+		}
+	}
+	for name := range cur {
+		if _, ok := old[name]; !ok {
+			created = append(created, name)
+		}
+	}
+	sort.Strings(removed)
+	sort.Strings(created)
+
+	// Pair up removed and created files that are in fact the same file
+	createdByID := make(map[entryID][]string)
+	for _, newName := range created {
+		if curEntry := cur[newName]; curEntry.hasID() {
+			id := curEntry.id()
+			createdByID[id] = append(createdByID[id], newName)
+		}
+	}
+	renamedFrom := make(map[string]struct{})
+	renamedTo := make(map[string]struct{})
+	for _, oldName := range removed {
+		oldEntry := old[oldName]
+		if !oldEntry.hasID() {
+			continue
+		}
+		id := oldEntry.id()
+		names := createdByID[id]
+		if len(names) == 0 {
+			continue
+		}
+		newName := names[0]
+		createdByID[id] = names[1:]
+		renamedFrom[oldName] = struct{}{}
+		renamedTo[newName] = struct{}{}
+		events = append(events, api.NewRenameEvent(oldName, newName, api.OpMask(api.OpRename)))
+	}
+
+	for _, name := range removed {
+		if _, ok := renamedFrom[name]; !ok {
+			events = append(events, api.NewEvent(name, api.OpMask(api.OpRemove)))
+		}
+	}
+	for _, name := range created {
+		if _, ok := renamedTo[name]; !ok {
+			events = append(events, api.NewEvent(name, api.OpMask(api.OpCreate)))
+		}
+	}
+
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].Name() < events[j].Name()
+	})
+	return events
+}
+
+// Filter returns the events that should be reported for ev to a
+// watch that is interested in ops, following the same rules as the
+// drivers: operations that were not requested are dropped, and
+// renames are split into their two halves if renames were not
+// requested.
+func Filter(ev api.Event, ops api.OpMask) []api.Event {
+	if rev, ok := ev.(api.RenameEvent); ok && !ops.IsSet(api.OpRename) {
+		// Not interested in renames, so report what each
+		// side would have seen individually
+		var events []api.Event
+		if ops.IsSet(api.OpRemove) {
+			events = append(events, api.NewEvent(rev.OldName(), api.OpMask(api.OpRemove)))
+		}
+		if ops.IsSet(api.OpCreate) {
+			events = append(events, api.NewEvent(rev.Name(), api.OpMask(api.OpCreate)))
+		}
+		return events
+	}
+
+	mask := ev.Mask() & ops
+	if mask == 0 {
+		return nil
+	}
+	if mask != ev.Mask() {
+		ev = api.NewEvent(ev.Name(), mask)
+	}
+	return []api.Event{ev}
+}
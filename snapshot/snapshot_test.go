@@ -0,0 +1,191 @@
+package snapshot_test
+
+import (
+	"crypto/sha256"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/lestrrat-go/fsnotify/api"
+	"github.com/lestrrat-go/fsnotify/snapshot"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiff(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fsnotify-snapshot-test-*")
+	if !assert.NoError(t, err, `ioutil.TempDir should succeed`) {
+		return
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	name := func(s string) string { return filepath.Join(dir, s) }
+	for _, s := range []string{"written", "chmoded", "removed", "renamed"} {
+		if !assert.NoError(t, ioutil.WriteFile(name(s), []byte(`Hello`), 0644), `ioutil.WriteFile should succeed`) {
+			return
+		}
+	}
+
+	old, errs := snapshot.Scan(dir, snapshot.WithRecursive(true))
+	if !assert.Len(t, errs, 0, `snapshot.Scan should succeed`) {
+		return
+	}
+
+	if !assert.NoError(t, ioutil.WriteFile(name("written"), []byte(`Hello, World!`), 0644), `ioutil.WriteFile should succeed`) {
+		return
+	}
+	if !assert.NoError(t, os.Chmod(name("chmoded"), 0600), `os.Chmod should succeed`) {
+		return
+	}
+	// Create before removing, so that the inode cannot be reused
+	if !assert.NoError(t, ioutil.WriteFile(name("created"), []byte(`Hello`), 0644), `ioutil.WriteFile should succeed`) {
+		return
+	}
+	if !assert.NoError(t, os.Remove(name("removed")), `os.Remove should succeed`) {
+		return
+	}
+	if !assert.NoError(t, os.Rename(name("renamed"), name("renamed.new")), `os.Rename should succeed`) {
+		return
+	}
+
+	cur, errs := snapshot.Scan(dir, snapshot.WithRecursive(true))
+	if !assert.Len(t, errs, 0, `snapshot.Scan should succeed`) {
+		return
+	}
+
+	expected := []api.Event{
+		api.NewEvent(name("chmoded"), api.OpMask(api.OpChmod)),
+		api.NewEvent(name("created"), api.OpMask(api.OpCreate)),
+		api.NewEvent(name("removed"), api.OpMask(api.OpRemove)),
+		api.NewRenameEvent(name("renamed"), name("renamed.new"), api.OpMask(api.OpRename)),
+		api.NewEvent(name("written"), api.OpMask(api.OpWrite)),
+	}
+	if !assert.Equal(t, expected, snapshot.Diff(old, cur), `events should match`) {
+		return
+	}
+}
+
+func TestHash(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fsnotify-snapshot-test-*")
+	if !assert.NoError(t, err, `ioutil.TempDir should succeed`) {
+		return
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	touched := filepath.Join(dir, "touched")
+	written := filepath.Join(dir, "written")
+	for _, name := range []string{touched, written} {
+		if !assert.NoError(t, ioutil.WriteFile(name, []byte(`Hello`), 0644), `ioutil.WriteFile should succeed`) {
+			return
+		}
+	}
+
+	old, errs := snapshot.Scan(dir, snapshot.WithHash(sha256.New))
+	if !assert.Len(t, errs, 0, `snapshot.Scan should succeed`) {
+		return
+	}
+	if !assert.NotEmpty(t, old[touched].Hash, `regular files should be hashed`) {
+		return
+	}
+	if !assert.Empty(t, old[dir].Hash, `directories should not be hashed`) {
+		return
+	}
+
+	// Same size, different contents, and the modification time is
+	// reset so that only the hash can tell the difference
+	mtime := old[written].ModTime
+	if !assert.NoError(t, ioutil.WriteFile(written, []byte(`World`), 0644), `ioutil.WriteFile should succeed`) {
+		return
+	}
+	if !assert.NoError(t, os.Chtimes(written, mtime, mtime), `os.Chtimes should succeed`) {
+		return
+	}
+	later := time.Now().Add(time.Hour)
+	if !assert.NoError(t, os.Chtimes(touched, later, later), `os.Chtimes should succeed`) {
+		return
+	}
+
+	cur, errs := snapshot.Scan(dir, snapshot.WithHash(sha256.New))
+	if !assert.Len(t, errs, 0, `snapshot.Scan should succeed`) {
+		return
+	}
+
+	expected := []api.Event{
+		api.NewEvent(written, api.OpMask(api.OpWrite)),
+	}
+	if !assert.Equal(t, expected, snapshot.Diff(old, cur), `only the file with new contents should be reported`) {
+		return
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fsnotify-snapshot-test-*")
+	if !assert.NoError(t, err, `ioutil.TempDir should succeed`) {
+		return
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	snap, errs := snapshot.Scan(dir, snapshot.WithRecursive(true))
+	if !assert.Len(t, errs, 0, `snapshot.Scan should succeed`) {
+		return
+	}
+
+	sub := filepath.Join(dir, "sub")
+	file := filepath.Join(sub, "file")
+	if !assert.NoError(t, os.Mkdir(sub, 0755), `os.Mkdir should succeed`) {
+		return
+	}
+	if !assert.NoError(t, ioutil.WriteFile(file, []byte(`Hello`), 0644), `ioutil.WriteFile should succeed`) {
+		return
+	}
+
+	t.Run("New directory", func(t *testing.T) {
+		if !assert.Len(t, snap.Update(sub, snapshot.WithRecursive(true)), 0, `snap.Update should succeed`) {
+			return
+		}
+		cur, _ := snapshot.Scan(dir, snapshot.WithRecursive(true))
+		if !assert.Len(t, snapshot.Diff(snap, cur), 0, `snapshot should be up to date`) {
+			return
+		}
+	})
+	t.Run("Removed directory", func(t *testing.T) {
+		if !assert.NoError(t, os.RemoveAll(sub), `os.RemoveAll should succeed`) {
+			return
+		}
+		if !assert.Len(t, snap.Update(sub, snapshot.WithRecursive(true)), 0, `snap.Update should succeed`) {
+			return
+		}
+		cur, _ := snapshot.Scan(dir, snapshot.WithRecursive(true))
+		if !assert.Len(t, snapshot.Diff(snap, cur), 0, `snapshot should be up to date`) {
+			return
+		}
+	})
+}
+
+func TestFilter(t *testing.T) {
+	rename := api.NewRenameEvent("/foo", "/bar", api.OpMask(api.OpRename))
+
+	t.Run("Rename requested", func(t *testing.T) {
+		events := snapshot.Filter(rename, api.DefaultOpMask)
+		if !assert.Equal(t, []api.Event{rename}, events, `rename should be reported as is`) {
+			return
+		}
+	})
+	t.Run("Rename not requested", func(t *testing.T) {
+		events := snapshot.Filter(rename, api.OpMask(api.OpCreate|api.OpRemove))
+		expected := []api.Event{
+			api.NewEvent("/foo", api.OpMask(api.OpRemove)),
+			api.NewEvent("/bar", api.OpMask(api.OpCreate)),
+		}
+		if !assert.Equal(t, expected, events, `rename should be split`) {
+			return
+		}
+	})
+	t.Run("Partially requested", func(t *testing.T) {
+		events := snapshot.Filter(api.NewEvent("/foo", api.OpMask(api.OpWrite|api.OpChmod)), api.OpMask(api.OpChmod))
+		if !assert.Equal(t, []api.Event{api.NewEvent("/foo", api.OpMask(api.OpChmod))}, events, `only CHMOD should be reported`) {
+			return
+		}
+	})
+}